@@ -0,0 +1,208 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+// V2SpecAnnotation keeps the v2 spec on v1 objects, so fields v1 cannot express survive a round trip.
+const V2SpecAnnotation = "hybridscaling.knativescaling.dcn.ssu.ac.kr/v2-spec"
+
+// V2StatusAnnotation keeps the v2 status on v1 objects, so a v1 client writing the status does not erase
+// what v1 cannot express, such as the original settings restored on deletion or the blocked levels.
+const V2StatusAnnotation = "hybridscaling.knativescaling.dcn.ssu.ac.kr/v2-status"
+
+// restoreAnnotation unmarshals the annotation into out and removes it, reporting whether it was there.
+func restoreAnnotation(meta *metav1.ObjectMeta, key string, out interface{}) (bool, error) {
+	data, ok := meta.Annotations[key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal([]byte(data), out); err != nil {
+		return false, err
+	}
+	delete(meta.Annotations, key)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+	return true, nil
+}
+
+// ConvertTo converts this TrafficStat to the hub version (v2).
+// spec.scalinginputtraffic must be a number, it becomes spec.traffic.value in concurrent requests.
+func (src *TrafficStat) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v2.TrafficStat)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	if _, err := restoreAnnotation(&dst.ObjectMeta, V2SpecAnnotation, &dst.Spec); err != nil {
+		return fmt.Errorf("unable to restore v2 spec of TrafficStat %s/%s: %w", src.Namespace, src.Name, err)
+	}
+	status := v2.TrafficStatStatus{}
+	if _, err := restoreAnnotation(&dst.ObjectMeta, V2StatusAnnotation, &status); err != nil {
+		return fmt.Errorf("unable to restore v2 status of TrafficStat %s/%s: %w", src.Namespace, src.Name, err)
+	}
+
+	// Fields present in v1 always win over the restored v2 spec
+	dst.Spec.TargetRef.Name = src.Spec.ServiceName
+	dst.Spec.TargetRef.Namespace = ""
+	if src.Spec.TargetRef != nil {
+		if src.Spec.TargetRef.Name != "" {
+			dst.Spec.TargetRef.Name = src.Spec.TargetRef.Name
+		}
+		dst.Spec.TargetRef.Namespace = src.Spec.TargetRef.Namespace
+	}
+	if dst.Spec.TargetRef.APIVersion == "" {
+		dst.Spec.TargetRef.APIVersion = "serving.knative.dev/v1"
+	}
+	if dst.Spec.TargetRef.Kind == "" {
+		dst.Spec.TargetRef.Kind = "Service"
+	}
+
+	traffic := resource.Quantity{}
+	if value := strings.TrimSpace(src.Spec.ScalingInputTraffic); value != "" {
+		var err error
+		if traffic, err = resource.ParseQuantity(value); err != nil {
+			return fmt.Errorf("spec.scalinginputtraffic %q of TrafficStat %s/%s is not a number: %w", src.Spec.ScalingInputTraffic, src.Namespace, src.Name, err)
+		}
+	}
+	dst.Spec.Traffic.Value = traffic
+	if dst.Spec.Traffic.Unit == "" {
+		dst.Spec.Traffic.Unit = v2.TrafficUnitConcurrency
+	}
+
+	// Status fields present in v1 win over the restored v2 status as well
+	status.ObservedGeneration = src.Status.ObservedGeneration
+	status.Conditions = src.Status.DeepCopy().Conditions
+	if d := src.Status.Decision; d == nil {
+		status.Decision = nil
+	} else {
+		if status.Decision == nil {
+			status.Decision = &v2.DecisionStatus{}
+		}
+		status.Decision.ResourceLevel = d.ResourceLevel
+		status.Decision.Concurrency = d.Concurrency
+		status.Decision.ExpectedPods = d.ExpectedPods
+		status.Decision.ExpectedTotalResources = d.ExpectedTotalResources
+		status.Decision.AppliedRevision = d.AppliedRevision
+		status.Decision.DecisionTime = d.DecisionTime.DeepCopy()
+		status.Decision.AppliedTime = d.AppliedTime.DeepCopy()
+	}
+	if ro := src.Status.Rollout; ro == nil {
+		status.Rollout = nil
+	} else {
+		if status.Rollout == nil {
+			status.Rollout = &v2.RolloutStatus{Pair: v2.HybridPair{Unit: v2.TrafficUnitConcurrency}}
+		}
+		status.Rollout.Phase = v2.RolloutPhase(ro.Phase)
+		status.Rollout.Pair.Resources = ro.Pair.Resources.DeepCopy()
+		status.Rollout.Pair.Concurrency = ro.Pair.Concurrency
+		status.Rollout.Pair.NumberOfPods = 0
+		if ro.Pair.NumberOfPods != "" {
+			pods, err := strconv.ParseInt(ro.Pair.NumberOfPods, 10, 32)
+			if err != nil {
+				return fmt.Errorf("status.rollout.pair.numberOfPods %q of TrafficStat %s/%s is not a number: %w", ro.Pair.NumberOfPods, src.Namespace, src.Name, err)
+			}
+			status.Rollout.Pair.NumberOfPods = int32(pods)
+		}
+		status.Rollout.PreviousRevision = ro.PreviousRevision
+		status.Rollout.ServiceGeneration = ro.ServiceGeneration
+		status.Rollout.NewRevision = ro.NewRevision
+		status.Rollout.Reason = ro.Reason
+		status.Rollout.Message = ro.Message
+		status.Rollout.LastTransitionTime = ro.LastTransitionTime.DeepCopy()
+	}
+	dst.Status = status
+	return nil
+}
+
+// ConvertFrom converts from the hub version (v2) to this version.
+func (dst *TrafficStat) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v2.TrafficStat)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	data, err := json.Marshal(src.Spec)
+	if err != nil {
+		return fmt.Errorf("unable to save v2 spec of TrafficStat %s/%s: %w", src.Namespace, src.Name, err)
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[V2SpecAnnotation] = string(data)
+	if data, err = json.Marshal(src.Status); err != nil {
+		return fmt.Errorf("unable to save v2 status of TrafficStat %s/%s: %w", src.Namespace, src.Name, err)
+	}
+	dst.Annotations[V2StatusAnnotation] = string(data)
+
+	dst.Spec = TrafficStatSpec{
+		ServiceName:         src.Spec.TargetRef.Name,
+		ScalingInputTraffic: strconv.FormatFloat(src.Spec.Traffic.Value.AsApproximateFloat64(), 'f', -1, 64),
+	}
+	if src.Spec.TargetRef.Namespace != "" {
+		dst.Spec.TargetRef = &TargetReference{Namespace: src.Spec.TargetRef.Namespace}
+	}
+
+	dst.Status = TrafficStatStatus{
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Conditions:         src.Status.DeepCopy().Conditions,
+	}
+	if d := src.Status.Decision; d != nil {
+		dst.Status.Decision = &DecisionStatus{
+			ResourceLevel:          d.ResourceLevel,
+			Concurrency:            d.Concurrency,
+			ExpectedPods:           d.ExpectedPods,
+			ExpectedTotalResources: d.ExpectedTotalResources,
+			AppliedRevision:        d.AppliedRevision,
+			DecisionTime:           d.DecisionTime.DeepCopy(),
+			AppliedTime:            d.AppliedTime.DeepCopy(),
+		}
+	}
+	if ro := src.Status.Rollout; ro != nil {
+		dst.Status.Rollout = &RolloutStatus{
+			Phase: RolloutPhase(ro.Phase),
+			Pair: HybridPair{
+				Resources:    ro.Pair.Resources.DeepCopy(),
+				Concurrency:  ro.Pair.Concurrency,
+				NumberOfPods: formatPods(ro.Pair.NumberOfPods),
+			},
+			PreviousRevision:   ro.PreviousRevision,
+			ServiceGeneration:  ro.ServiceGeneration,
+			NewRevision:        ro.NewRevision,
+			Reason:             ro.Reason,
+			Message:            ro.Message,
+			LastTransitionTime: ro.LastTransitionTime.DeepCopy(),
+		}
+	}
+	return nil
+}
+
+// formatPods returns the v1 string of a number of pods, empty when it is not set.
+func formatPods(pods int32) string {
+	if pods == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(pods))
+}
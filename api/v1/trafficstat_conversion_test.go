@@ -0,0 +1,123 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestConvertToParsesProducerTrafficStat(t *testing.T) {
+	src := &TrafficStat{
+		ObjectMeta: metav1.ObjectMeta{Name: "deploy-a-traffic", Namespace: "default"},
+		Spec:       TrafficStatSpec{ServiceName: "deploy-a", ScalingInputTraffic: "12.5"},
+	}
+	dst := &v2.TrafficStat{}
+	if err := src.ConvertTo(dst); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if dst.Spec.TargetRef.Name != "deploy-a" || dst.Spec.TargetRef.Kind != "Service" {
+		t.Errorf("targetRef = %+v, want Service deploy-a", dst.Spec.TargetRef)
+	}
+	if want := resource.MustParse("12.5"); dst.Spec.Traffic.Value.Cmp(want) != 0 {
+		t.Errorf("traffic value = %s, want %s", dst.Spec.Traffic.Value.String(), want.String())
+	}
+	if dst.Spec.Traffic.Unit != v2.TrafficUnitConcurrency {
+		t.Errorf("traffic unit = %q, want %q", dst.Spec.Traffic.Unit, v2.TrafficUnitConcurrency)
+	}
+}
+
+func TestConvertToRejectsNonNumericTraffic(t *testing.T) {
+	src := &TrafficStat{Spec: TrafficStatSpec{ServiceName: "deploy-a", ScalingInputTraffic: "lots"}}
+	if err := src.ConvertTo(&v2.TrafficStat{}); err == nil {
+		t.Fatal("ConvertTo() succeeded for non-numeric traffic")
+	}
+}
+
+func TestConversionRoundTripKeepsV2Fields(t *testing.T) {
+	switchTime := metav1.NewTime(time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC))
+	level := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")}
+	hub := &v2.TrafficStat{
+		ObjectMeta: metav1.ObjectMeta{Name: "deploy-a-traffic", Namespace: "default"},
+		Spec: v2.TrafficStatSpec{
+			TargetRef: v2.TargetReference{APIVersion: "serving.knative.dev/v1", Kind: "Service", Name: "deploy-a", Namespace: "tenant-a"},
+			Traffic:   v2.PredictedTraffic{Value: resource.MustParse("40"), Unit: v2.TrafficUnitRPS},
+		},
+		Status: v2.TrafficStatStatus{
+			Mode:           v2.ScalingModeOverride,
+			LastSwitchTime: &switchTime,
+			BlockedLevels:  []v2.BlockedLevel{{Resources: level, Until: switchTime, Reason: "ImagePullBackOff"}},
+			Original:       &v2.TemplateSnapshot{Annotations: map[string]string{"autoscaling.knative.dev/min-scale": "1"}},
+			Decision: &v2.DecisionStatus{
+				Concurrency: "10",
+				Scale:       &v2.ScaleStatus{Predicted: 14, Applied: 12, LimitedBy: v2.ScaleLimitMaxScale},
+				Candidates:  []v2.CandidateStatus{{ResourceLevel: "1500m", Concurrency: "10", ExpectedPods: 14}},
+			},
+			Rollout: &v2.RolloutStatus{
+				Phase:          v2.RolloutPhaseShifting,
+				Pair:           v2.HybridPair{Resources: level, Concurrency: "10", NumberOfPods: 12, Unit: v2.TrafficUnitRPS, Annotations: map[string]string{"autoscaling.knative.dev/max-scale": "15"}},
+				Strategy:       v2.RolloutStrategyGradual,
+				Steps:          []int32{20, 50, 100},
+				TrafficPercent: 20,
+				StepInterval:   &metav1.Duration{Duration: 30 * time.Second},
+				KnownGood:      &v2.TemplateSnapshot{Annotations: map[string]string{"autoscaling.knative.dev/target": "5"}},
+			},
+		},
+	}
+	spoke := &TrafficStat{}
+	if err := spoke.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if spoke.Spec.ServiceName != "deploy-a" || spoke.Spec.ScalingInputTraffic != "40" {
+		t.Errorf("v1 spec = %+v, want servicename deploy-a and traffic 40", spoke.Spec)
+	}
+
+	// A v1 client only updates the traffic value and the fields of the status it knows
+	spoke.Spec.ScalingInputTraffic = "55"
+	spoke.Status.Decision.Concurrency = "20"
+	spoke.Status.Rollout.Phase = RolloutPhaseDraining
+	restored := &v2.TrafficStat{}
+	if err := spoke.ConvertTo(restored); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if restored.Spec.Traffic.Unit != v2.TrafficUnitRPS || restored.Spec.TargetRef.Namespace != "tenant-a" {
+		t.Errorf("v2 spec = %+v, want unit RPS and namespace tenant-a kept", restored.Spec)
+	}
+	if want := resource.MustParse("55"); restored.Spec.Traffic.Value.Cmp(want) != 0 {
+		t.Errorf("traffic value = %s, want %s", restored.Spec.Traffic.Value.String(), want.String())
+	}
+	for _, annotation := range []string{V2SpecAnnotation, V2StatusAnnotation} {
+		if _, ok := restored.Annotations[annotation]; ok {
+			t.Errorf("annotation %s leaked into the v2 object", annotation)
+		}
+	}
+
+	// Status fields v1 cannot express survive, those it can are taken from v1
+	want := hub.Status.DeepCopy()
+	want.Decision.Concurrency = "20"
+	want.Rollout.Phase = v2.RolloutPhaseDraining
+	if !equality.Semantic.DeepEqual(&restored.Status, want) {
+		t.Errorf("v2 status = %+v, want %+v", restored.Status, *want)
+	}
+}
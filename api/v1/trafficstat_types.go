@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -31,16 +32,178 @@ type TrafficStatSpec struct {
 	// Foo is an example field of TrafficStat. Edit trafficstat_types.go to remove/update
 	ServiceName         string `json:"servicename,omitempty"`
 	ScalingInputTraffic string `json:"scalinginputtraffic,omitempty"`
+
+	// TargetRef optionally points to the Knative Service in another namespace.
+	// When unset, the service named by ServiceName and its hybrid profile are looked up
+	// in the TrafficStat's own namespace.
+	// +optional
+	TargetRef *TargetReference `json:"targetRef,omitempty"`
+}
+
+// TargetReference identifies the Knative Service scaled by a TrafficStat
+type TargetReference struct {
+	// Name of the Knative Service. Defaults to spec.servicename.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Namespace of the Knative Service and its hybrid profile. Defaults to the TrafficStat's namespace.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
 }
 
 // TrafficStatStatus defines the observed state of TrafficStat
 type TrafficStatStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// ObservedGeneration is the TrafficStat generation the status was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe each step from prediction to running revision:
+	// ProfileFound, ServiceFound, DecisionComputed, RevisionReady and OldRevisionCleaned.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// Decision is the resource-concurrency pair chosen for the predicted traffic.
+	// +optional
+	Decision *DecisionStatus `json:"decision,omitempty"`
+
+	// Rollout tracks the switch of the target service to a new resource-concurrency pair.
+	// It lets the controller resume an interrupted rollout after a restart.
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+}
+
+// Condition types reported in TrafficStatStatus.Conditions
+const (
+	// ConditionProfileFound is True when the hybrid profile of the target service was read.
+	ConditionProfileFound = "ProfileFound"
+	// ConditionServiceFound is True when the target Knative Service exists.
+	ConditionServiceFound = "ServiceFound"
+	// ConditionDecisionComputed is True when a resource-concurrency pair was chosen for the predicted traffic.
+	ConditionDecisionComputed = "DecisionComputed"
+	// ConditionRevisionReady is True when the revision running the chosen pair is Ready.
+	ConditionRevisionReady = "RevisionReady"
+	// ConditionOldRevisionCleaned is True when the revision replaced by the last rollout was removed.
+	ConditionOldRevisionCleaned = "OldRevisionCleaned"
+)
+
+// DecisionStatus is the resource-concurrency pair chosen for the predicted traffic
+type DecisionStatus struct {
+	// ResourceLevel is the chosen level of the service's intensive resource, e.g. 1500m or 512Mi.
+	// +optional
+	ResourceLevel string `json:"resourceLevel,omitempty"`
+
+	// Concurrency is the chosen autoscaling.knative.dev/target value.
+	// +optional
+	Concurrency string `json:"concurrency,omitempty"`
+
+	// ExpectedPods is the number of pods needed to serve the predicted traffic with the chosen pair.
+	// +optional
+	ExpectedPods int32 `json:"expectedPods,omitempty"`
+
+	// ExpectedTotalResources is ExpectedPods times ResourceLevel.
+	// +optional
+	ExpectedTotalResources string `json:"expectedTotalResources,omitempty"`
+
+	// AppliedRevision is the revision that runs the chosen pair, empty until it is rolled out.
+	// +optional
+	AppliedRevision string `json:"appliedRevision,omitempty"`
+
+	// DecisionTime is when the chosen pair last changed.
+	// +optional
+	DecisionTime *metav1.Time `json:"decisionTime,omitempty"`
+
+	// AppliedTime is when AppliedRevision became Ready.
+	// +optional
+	AppliedTime *metav1.Time `json:"appliedTime,omitempty"`
+}
+
+// RolloutPhase is a step of the switch of a service to a new resource-concurrency pair
+// +kubebuilder:validation:Enum=Applying;WaitingForRevision;Shifting;Draining;RollingBack;Done;Failed
+type RolloutPhase string
+
+const (
+	// RolloutPhaseApplying means the chosen pair is being written to the Knative Service.
+	RolloutPhaseApplying RolloutPhase = "Applying"
+	// RolloutPhaseWaitingForRevision means the controller waits for the new revision to serve.
+	RolloutPhaseWaitingForRevision RolloutPhase = "WaitingForRevision"
+	// RolloutPhaseShifting means traffic moves from the previous revision to the new one in steps.
+	RolloutPhaseShifting RolloutPhase = "Shifting"
+	// RolloutPhaseDraining means the previous revision and its pods are being removed.
+	RolloutPhaseDraining RolloutPhase = "Draining"
+	// RolloutPhaseRollingBack means the new revision failed and the previous template is being restored.
+	RolloutPhaseRollingBack RolloutPhase = "RollingBack"
+	// RolloutPhaseDone means the service runs with the chosen pair.
+	RolloutPhaseDone RolloutPhase = "Done"
+	// RolloutPhaseFailed means the new revision did not become ready, see Reason and Message.
+	RolloutPhaseFailed RolloutPhase = "Failed"
+)
+
+// HybridPair is a resource-concurrency pair and the number of pods it is applied with
+type HybridPair struct {
+	// Resources are the container requests and limits of the pair.
+	// +optional
+	Resources corev1.ResourceList `json:"resources,omitempty"`
+
+	// Concurrency is the autoscaling.knative.dev/target value of the pair.
+	// +optional
+	Concurrency string `json:"concurrency,omitempty"`
+
+	// NumberOfPods is the initial-scale and min-scale value of the pair.
+	// +optional
+	NumberOfPods string `json:"numberOfPods,omitempty"`
+}
+
+// RolloutStatus describes the rollout of a hybrid pair to the target service
+type RolloutStatus struct {
+	// Phase is the current step of the rollout.
+	Phase RolloutPhase `json:"phase"`
+
+	// Pair is the resource-concurrency pair being rolled out.
+	Pair HybridPair `json:"pair"`
+
+	// PreviousRevision is the revision that served before the rollout started.
+	// +optional
+	PreviousRevision string `json:"previousRevision,omitempty"`
+
+	// ServiceGeneration is the generation of the Knative Service once Pair was applied.
+	// The revision Knative creates for that generation is the one the rollout waits for.
+	// +optional
+	ServiceGeneration int64 `json:"serviceGeneration,omitempty"`
+
+	// NewRevision is the revision created for Pair, as reported in the Service's latestCreatedRevisionName.
+	// +optional
+	NewRevision string `json:"newRevision,omitempty"`
+
+	// Reason is a CamelCase reason for the last phase transition, set when the rollout failed.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message is a human readable explanation of Reason.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastTransitionTime is when Phase last changed.
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.servicename`
+//+kubebuilder:printcolumn:name="Traffic",type=string,JSONPath=`.spec.scalinginputtraffic`
+//+kubebuilder:printcolumn:name="Resources",type=string,JSONPath=`.status.decision.resourceLevel`
+//+kubebuilder:printcolumn:name="Concurrency",type=string,JSONPath=`.status.decision.concurrency`
+//+kubebuilder:printcolumn:name="Pods",type=integer,JSONPath=`.status.decision.expectedPods`
+//+kubebuilder:printcolumn:name="Revision",type=string,JSONPath=`.status.decision.appliedRevision`
+//+kubebuilder:printcolumn:name="Rollout",type=string,JSONPath=`.status.rollout.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // TrafficStat is the Schema for the trafficstats API
 type TrafficStat struct {
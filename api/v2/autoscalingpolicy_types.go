@@ -0,0 +1,180 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ManagedAnnotation is a Knative autoscaling annotation the controller can set from the hybrid decision
+// +kubebuilder:validation:Enum=TargetUtilization;MaxScale;TargetBurstCapacity;PanicWindowPercentage;ScaleDownDelay
+type ManagedAnnotation string
+
+const (
+	// ManagedTargetUtilization sets target-utilization-percentage to the utilization the decision was computed with.
+	ManagedTargetUtilization ManagedAnnotation = "TargetUtilization"
+	// ManagedMaxScale sets max-scale to the predicted pods plus MaxScaleHeadroomPercentage.
+	ManagedMaxScale ManagedAnnotation = "MaxScale"
+	// ManagedTargetBurstCapacity sets target-burst-capacity to TargetBurstCapacity, or to the headroom share of the predicted concurrency.
+	ManagedTargetBurstCapacity ManagedAnnotation = "TargetBurstCapacity"
+	// ManagedPanicWindowPercentage sets panic-window-percentage to PanicWindowPercentage.
+	ManagedPanicWindowPercentage ManagedAnnotation = "PanicWindowPercentage"
+	// ManagedScaleDownDelay sets scale-down-delay to ScaleDownDelay.
+	ManagedScaleDownDelay ManagedAnnotation = "ScaleDownDelay"
+)
+
+// AutoscalingPolicy selects the Knative autoscaling annotations set next to target, initial-scale and min-scale,
+// so that KPA's reactive scaling works around the predicted pair
+type AutoscalingPolicy struct {
+	// Managed lists the annotations the controller sets. Annotations not listed are left to the Service owner.
+	// +listType=set
+	// +optional
+	Managed []ManagedAnnotation `json:"managed,omitempty"`
+
+	// MaxScaleHeadroomPercentage is added to the predicted pods to get max-scale, and to the predicted
+	// concurrency to derive target-burst-capacity. Defaults to 20.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxScaleHeadroomPercentage *int32 `json:"maxScaleHeadroomPercentage,omitempty"`
+
+	// TargetBurstCapacity is the target-burst-capacity value, -1 keeps the activator in the request path.
+	// Derived from the prediction and MaxScaleHeadroomPercentage when unset.
+	// +kubebuilder:validation:Minimum=-1
+	// +optional
+	TargetBurstCapacity *int32 `json:"targetBurstCapacity,omitempty"`
+
+	// PanicWindowPercentage is the panic-window-percentage value. Defaults to Knative's 10.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	PanicWindowPercentage *int32 `json:"panicWindowPercentage,omitempty"`
+
+	// ScaleDownDelay is the scale-down-delay value, e.g. 1m to hold pods until the next prediction. Defaults to 0s.
+	// +optional
+	ScaleDownDelay *metav1.Duration `json:"scaleDownDelay,omitempty"`
+}
+
+// Manages reports whether the policy manages the annotation.
+func (p *AutoscalingPolicy) Manages(annotation ManagedAnnotation) bool {
+	if p == nil {
+		return false
+	}
+	for _, managed := range p.Managed {
+		if managed == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+// StabilizationPolicy keeps small prediction changes from switching the target between pairs,
+// as every switch mints a new revision and cold starts its pods
+type StabilizationPolicy struct {
+	// Cooldown is the minimum time between two pair switches. Pod count changes are not held.
+	// +optional
+	Cooldown *metav1.Duration `json:"cooldown,omitempty"`
+
+	// MinImprovementPercentage is how much smaller, in percent, the total resources of the best pair must be
+	// than those of the current pair for the same prediction before switching. Below it the current pair is kept
+	// and only min-scale follows the prediction.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	MinImprovementPercentage *int32 `json:"minImprovementPercentage,omitempty"`
+
+	// MinScaleTolerancePercentage is how much, in percent of the current min-scale, the predicted pod count
+	// may differ from it before min-scale is updated.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinScaleTolerancePercentage *int32 `json:"minScaleTolerancePercentage,omitempty"`
+}
+
+// RolloutStrategy is how traffic moves from the previous revision to the revision of a new pair
+// +kubebuilder:validation:Enum=Replace;Gradual
+type RolloutStrategy string
+
+const (
+	// RolloutStrategyReplace sends all traffic to the new revision once it is Ready.
+	RolloutStrategyReplace RolloutStrategy = "Replace"
+	// RolloutStrategyGradual pins the Service's traffic to both revisions and shifts it in steps as new pods
+	// become ready. The previous revision is then left to Knative's garbage collection.
+	RolloutStrategyGradual RolloutStrategy = "Gradual"
+)
+
+// CleanupPolicy is how the previous revision is removed once the new one serves all traffic
+// +kubebuilder:validation:Enum=KnativeGC;DeleteRevision;Drain;ForceDelete
+type CleanupPolicy string
+
+const (
+	// CleanupKnativeGC leaves the previous revision to Knative, which scales it to zero and garbage collects it.
+	CleanupKnativeGC CleanupPolicy = "KnativeGC"
+	// CleanupDeleteRevision deletes the previous revision, its pods terminate with their grace period.
+	CleanupDeleteRevision CleanupPolicy = "DeleteRevision"
+	// CleanupDrain deletes the previous revision and waits for its pods to terminate with their grace period.
+	CleanupDrain CleanupPolicy = "Drain"
+	// CleanupForceDelete deletes the previous revision and its pods without grace period,
+	// for edge nodes that cannot hold both revisions while the old pods terminate.
+	CleanupForceDelete CleanupPolicy = "ForceDelete"
+)
+
+// RevisionNaming is how a rollout names the revision of a Service that sets its own revision name
+// +kubebuilder:validation:Enum=Generate;Clear
+type RevisionNaming string
+
+const (
+	// RevisionNamingGenerate gives the revision template a new name, <service>-hybrid-<generation>, with every change.
+	RevisionNamingGenerate RevisionNaming = "Generate"
+	// RevisionNamingClear removes the revision name from the template, Knative generates the names from then on.
+	RevisionNamingClear RevisionNaming = "Clear"
+)
+
+// RolloutPolicy configures the switch of the target to a new pair
+type RolloutPolicy struct {
+	// Strategy is how traffic moves to the new revision. Defaults to Replace.
+	// +optional
+	Strategy RolloutStrategy `json:"strategy,omitempty"`
+
+	// Steps are the increasing traffic percentages routed to the new revision by the Gradual strategy.
+	// A final 100 is added when missing. Defaults to 20, 50, 100.
+	// +kubebuilder:validation:MaxItems=10
+	// +optional
+	Steps []int32 `json:"steps,omitempty"`
+
+	// StepInterval is the minimum time a Gradual step serves before the next one. Defaults to 30s.
+	// +optional
+	StepInterval *metav1.Duration `json:"stepInterval,omitempty"`
+
+	// Deadline is how long the new revision may take to become Ready, and each Gradual step to get its pods,
+	// before the previous template is restored. It also bounds the cleanup of the previous revision, which is then
+	// left to Knative. Defaults to the controller's --revision-ready-timeout.
+	// +optional
+	Deadline *metav1.Duration `json:"deadline,omitempty"`
+
+	// FailureBackoff is how long a resource level whose rollout failed is not chosen again. Defaults to 30m.
+	// +optional
+	FailureBackoff *metav1.Duration `json:"failureBackoff,omitempty"`
+
+	// RevisionNaming is how the new revision is named when the Service sets spec.template.metadata.name,
+	// which Knative requires to change with every template change. Defaults to Generate.
+	// +optional
+	RevisionNaming RevisionNaming `json:"revisionNaming,omitempty"`
+
+	// Cleanup is how the previous revision is removed. Every policy but KnativeGC waits for the
+	// PodDisruptionBudgets of its pods to allow their disruption. Defaults to KnativeGC.
+	// +optional
+	Cleanup CleanupPolicy `json:"cleanup,omitempty"`
+}
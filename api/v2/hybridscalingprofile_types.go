@@ -0,0 +1,214 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HybridScalingProfileSpec defines the resource-optimal concurrency pairs measured for a service
+type HybridScalingProfileSpec struct {
+	// MeasuredFor is the Service or image the profile was measured for.
+	// +optional
+	MeasuredFor ProfileSubject `json:"measuredFor,omitempty"`
+
+	// IntensiveResourceType is the resource the service is scaled on. Entries that give a level (Resources)
+	// give a level of this resource, it is required when the profile has such entries.
+	// +kubebuilder:validation:Enum=cpu;memory
+	// +optional
+	IntensiveResourceType corev1.ResourceName `json:"intensiveResourceType,omitempty"`
+
+	// FixedResources are set on every revision next to the chosen level, e.g. memory: 200Mi for a cpu intensive service.
+	// Entries with a ResourceList do not use them.
+	// +optional
+	FixedResources corev1.ResourceList `json:"fixedResources,omitempty"`
+
+	// Entries are the measured resource-optimal concurrency pairs.
+	// +kubebuilder:validation:MinItems=1
+	// +listType=atomic
+	Entries []ProfileEntry `json:"entries"`
+
+	// TargetUtilizationPercentage is the share of the optimal concurrency KPA loads each pod with.
+	// The service's autoscaling.knative.dev/target-utilization-percentage annotation takes precedence,
+	// Knative's container-concurrency-target-percentage (default 70) is used when neither is set.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	TargetUtilizationPercentage *int32 `json:"targetUtilizationPercentage,omitempty"`
+
+	// AutoscalingPolicy selects the Knative autoscaling annotations derived from the decision.
+	// A TrafficStat's autoscalingPolicy replaces it.
+	// +optional
+	AutoscalingPolicy *AutoscalingPolicy `json:"autoscalingPolicy,omitempty"`
+
+	// Stabilization limits how often the service switches pairs.
+	// A TrafficStat's stabilization replaces it.
+	// +optional
+	Stabilization *StabilizationPolicy `json:"stabilization,omitempty"`
+
+	// RolloutPolicy is how traffic moves to the revision of a new pair.
+	// A TrafficStat's rolloutPolicy replaces it.
+	// +optional
+	RolloutPolicy *RolloutPolicy `json:"rolloutPolicy,omitempty"`
+
+	// Optimizer is the strategy choosing among the entries, e.g. MinTotalResources or MinPods.
+	// Defaults to MinTotalResources.
+	// +optional
+	Optimizer string `json:"optimizer,omitempty"`
+
+	// Objective weighs the resources of an entry against each other when totals are compared.
+	// +optional
+	Objective *Objective `json:"objective,omitempty"`
+
+	// TieBreak orders entries the optimizer ranks equal, the first rule that tells them apart wins.
+	// Defaults to FewerPods then SmallerLevel. Entries still equal are ordered by higher concurrency, then profile order.
+	// A TrafficStat's tieBreak replaces it.
+	// +kubebuilder:validation:MaxItems=2
+	// +optional
+	TieBreak []TieBreaker `json:"tieBreak,omitempty"`
+}
+
+// TieBreaker is a rule ordering entries the optimizer ranks equal
+// +kubebuilder:validation:Enum=FewerPods;MorePods;SmallerLevel;LargerLevel
+type TieBreaker string
+
+const (
+	// TieBreakFewerPods prefers the entry needing fewer pods.
+	TieBreakFewerPods TieBreaker = "FewerPods"
+	// TieBreakMorePods prefers the entry needing more pods, which spreads the load.
+	TieBreakMorePods TieBreaker = "MorePods"
+	// TieBreakSmallerLevel prefers the entry whose pods cost less.
+	TieBreakSmallerLevel TieBreaker = "SmallerLevel"
+	// TieBreakLargerLevel prefers the entry whose pods cost more.
+	TieBreakLargerLevel TieBreaker = "LargerLevel"
+)
+
+// EntryResources returns the requests and limits of a pod of the entry: its ResourceList,
+// else its level of the intensive resource next to the fixed resources.
+func (s *HybridScalingProfileSpec) EntryResources(entry *ProfileEntry) corev1.ResourceList {
+	if len(entry.ResourceList) > 0 {
+		return entry.ResourceList.DeepCopy()
+	}
+	resources := s.FixedResources.DeepCopy()
+	if resources == nil {
+		resources = corev1.ResourceList{}
+	}
+	resources[s.IntensiveResourceType] = entry.Resources.DeepCopy()
+	return resources
+}
+
+// ObjectiveWeighting is how the resources of an entry are weighed against each other
+// +kubebuilder:validation:Enum=IntensiveResource;NodeShape;Price
+type ObjectiveWeighting string
+
+const (
+	// ObjectiveIntensiveResource counts the intensive resource only.
+	// It is the default for profiles whose entries all give a level.
+	ObjectiveIntensiveResource ObjectiveWeighting = "IntensiveResource"
+	// ObjectiveNodeShape weighs each resource by the share it takes of the largest allocatable amount on a schedulable node.
+	// It is the default for profiles with ResourceList entries.
+	ObjectiveNodeShape ObjectiveWeighting = "NodeShape"
+	// ObjectivePrice weighs each resource by its price, resources without a price are free.
+	ObjectivePrice ObjectiveWeighting = "Price"
+)
+
+// Objective weighs the resources of an entry against each other
+type Objective struct {
+	// Weighting is how the resources are weighed.
+	// +optional
+	Weighting ObjectiveWeighting `json:"weighting,omitempty"`
+
+	// Prices are the prices per unit of the resources for the Price weighting.
+	// +listType=map
+	// +listMapKey=resource
+	// +optional
+	Prices []ResourcePrice `json:"prices,omitempty"`
+}
+
+// ResourcePrice is the price of a resource
+type ResourcePrice struct {
+	// Resource is the priced resource, e.g. cpu or memory.
+	Resource corev1.ResourceName `json:"resource"`
+
+	// Unit is the amount of the resource the price is given for, e.g. 1 for cpu or 1Gi for memory. Defaults to 1.
+	// +optional
+	Unit *resource.Quantity `json:"unit,omitempty"`
+
+	// Price of Unit of the resource.
+	Price resource.Quantity `json:"price"`
+}
+
+// ProfileSubject identifies what a profile was measured for
+type ProfileSubject struct {
+	// ServiceName is the Knative Service the profile was measured on.
+	// +optional
+	ServiceName string `json:"serviceName,omitempty"`
+
+	// Image is the container image the profile was measured with.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// ProfileEntry is one measured resource level and its optimal concurrency.
+// It gives either a level of the intensive resource or a full ResourceList.
+type ProfileEntry struct {
+	// Resources is the level of the intensive resource, e.g. 1500m for cpu or 512Mi for memory.
+	// +optional
+	Resources resource.Quantity `json:"resources,omitempty"`
+
+	// ResourceList are the requests and limits of a pod of the entry, e.g. cpu and memory together,
+	// optionally with ephemeral-storage.
+	// +optional
+	ResourceList corev1.ResourceList `json:"resourceList,omitempty"`
+
+	// OptimalConcurrency is the maximum number of concurrent requests a pod with this level serves within the latency SLO.
+	// +kubebuilder:validation:Minimum=1
+	OptimalConcurrency int32 `json:"optimalConcurrency"`
+
+	// LatencySLO is the latency the optimal concurrency was measured against.
+	// +optional
+	LatencySLO *metav1.Duration `json:"latencySLO,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:shortName=hsp
+//+kubebuilder:printcolumn:name="Resource",type=string,JSONPath=`.spec.intensiveResourceType`
+//+kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.measuredFor.serviceName`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HybridScalingProfile is the Schema for the hybridscalingprofiles API
+type HybridScalingProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec HybridScalingProfileSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HybridScalingProfileList contains a list of HybridScalingProfile
+type HybridScalingProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HybridScalingProfile `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HybridScalingProfile{}, &HybridScalingProfileList{})
+}
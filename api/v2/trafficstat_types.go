@@ -0,0 +1,554 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TrafficStatSpec defines the desired state of TrafficStat
+type TrafficStatSpec struct {
+	// TargetRef is the Knative Service scaled for the predicted traffic.
+	TargetRef TargetReference `json:"targetRef"`
+
+	// Traffic is the predicted load the target has to serve.
+	Traffic PredictedTraffic `json:"traffic"`
+
+	// ProfileRef names the HybridScalingProfile of the target, in the target's namespace.
+	// Defaults to a profile named after the target. When no such profile exists the
+	// legacy "hybrid-<target name>" ConfigMap is read.
+	// +optional
+	ProfileRef *corev1.LocalObjectReference `json:"profileRef,omitempty"`
+
+	// Optimizer is the strategy choosing the resource-concurrency pair, e.g. MinTotalResources or MinPods.
+	// Overrides the profile's optimizer. Defaults to MinTotalResources.
+	// +optional
+	Optimizer string `json:"optimizer,omitempty"`
+
+	// TieBreak orders pairs the optimizer ranks equal. Replaces the profile's tieBreak.
+	// +kubebuilder:validation:MaxItems=2
+	// +optional
+	TieBreak []TieBreaker `json:"tieBreak,omitempty"`
+
+	// AutoscalingPolicy selects the Knative autoscaling annotations derived from the decision.
+	// Replaces the profile's autoscalingPolicy.
+	// +optional
+	AutoscalingPolicy *AutoscalingPolicy `json:"autoscalingPolicy,omitempty"`
+	// Stabilization limits how often the target switches pairs. Replaces the profile's stabilization.
+	// +optional
+	Stabilization *StabilizationPolicy `json:"stabilization,omitempty"`
+	// RolloutPolicy is how traffic moves to the revision of a new pair. Replaces the profile's rolloutPolicy.
+	// +optional
+	RolloutPolicy *RolloutPolicy `json:"rolloutPolicy,omitempty"`
+	// Fallback is what is done with the target while neither its HybridScalingProfile nor its legacy ConfigMap exists.
+	// Defaults to leaving KPA alone.
+	// +optional
+	Fallback *FallbackPolicy `json:"fallback,omitempty"`
+	// OnDelete is what is done with the target when the TrafficStat is deleted. Defaults to restoring the settings
+	// the target had before the controller took it over.
+	// +optional
+	OnDelete *DeletionPolicy `json:"onDelete,omitempty"`
+	// Suspend stops applying decisions to the target, they are still computed and reported in the status.
+	// A rollout in progress is driven to its end.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+	// Override pins the pair and pod count of the target until it expires, then predictive scaling resumes.
+	// +optional
+	Override *ScalingOverride `json:"override,omitempty"`
+}
+
+// ScalingOverride pins the resource-concurrency pair and pod count of the target for a time window
+type ScalingOverride struct {
+	// ResourceLevel is the level of the profile's intensive resource, applied next to the profile's fixed resources.
+	// +optional
+	ResourceLevel resource.Quantity `json:"resourceLevel,omitempty"`
+
+	// ResourceList are the requests and limits of a pod, used instead of ResourceLevel.
+	// +optional
+	ResourceList corev1.ResourceList `json:"resourceList,omitempty"`
+
+	// Concurrency is the autoscaling.knative.dev/target value.
+	// +kubebuilder:validation:Minimum=1
+	Concurrency int32 `json:"concurrency"`
+
+	// Pods is the initial-scale and min-scale value.
+	// +kubebuilder:validation:Minimum=0
+	Pods int32 `json:"pods"`
+
+	// Until is when the override expires.
+	Until metav1.Time `json:"until"`
+}
+
+// DeletionMode is what is done with the target service when its TrafficStat is deleted
+// +kubebuilder:validation:Enum=Restore;Baseline;Keep
+type DeletionMode string
+
+const (
+	// DeletionRestore puts back the autoscaling annotations and container resources recorded in status.original.
+	DeletionRestore DeletionMode = "Restore"
+	// DeletionBaseline applies the Baseline of the DeletionPolicy.
+	DeletionBaseline DeletionMode = "Baseline"
+	// DeletionKeep leaves the last applied pair on the service.
+	DeletionKeep DeletionMode = "Keep"
+)
+
+// DeletionPolicy is what is done with the target service when its TrafficStat is deleted
+type DeletionPolicy struct {
+	// Mode of the deletion.
+	// +kubebuilder:default=Restore
+	// +optional
+	Mode DeletionMode `json:"mode,omitempty"`
+
+	// Baseline is the template the Baseline mode applies. Annotations with an empty value are removed,
+	// container resources are kept when Baseline has none.
+	// +optional
+	Baseline *TemplateSnapshot `json:"baseline,omitempty"`
+}
+
+// FallbackMode is what is done with a service that has no hybrid profile
+// +kubebuilder:validation:Enum=None;MinScale;NamespaceDefault
+type FallbackMode string
+
+const (
+	// FallbackNone leaves the Knative autoscaling settings of the service as they are.
+	FallbackNone FallbackMode = "None"
+	// FallbackMinScale sets min-scale of the running revision to the pods the predicted traffic needs at Concurrency.
+	// Container resources are not changed.
+	FallbackMinScale FallbackMode = "MinScale"
+	// FallbackNamespaceDefault uses the HybridScalingProfile named ProfileName in the target's namespace.
+	FallbackNamespaceDefault FallbackMode = "NamespaceDefault"
+)
+
+// FallbackPolicy is what is done with a service that has no hybrid profile
+type FallbackPolicy struct {
+	// Mode of the fallback.
+	// +kubebuilder:default=None
+	// +optional
+	Mode FallbackMode `json:"mode,omitempty"`
+
+	// Concurrency is the per pod target the MinScale mode counts pods with.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Concurrency *int32 `json:"concurrency,omitempty"`
+
+	// ProfileName is the HybridScalingProfile the NamespaceDefault mode uses. Defaults to "default".
+	// +optional
+	ProfileName string `json:"profileName,omitempty"`
+}
+
+// TargetReference identifies the Knative Service scaled by a TrafficStat
+type TargetReference struct {
+	// APIVersion of the target.
+	// +kubebuilder:validation:Enum="serving.knative.dev/v1"
+	// +kubebuilder:default="serving.knative.dev/v1"
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Kind of the target.
+	// +kubebuilder:validation:Enum=Service
+	// +kubebuilder:default=Service
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Name of the target.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+
+	// Namespace of the target and its hybrid profile. Defaults to the TrafficStat's namespace.
+	// +kubebuilder:validation:MaxLength=63
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// TrafficUnit is the unit of a traffic prediction
+// +kubebuilder:validation:Enum=Concurrency;RPS
+type TrafficUnit string
+
+const (
+	// TrafficUnitConcurrency measures traffic in concurrent in-flight requests.
+	// The service is scaled on Knative's concurrency metric.
+	TrafficUnitConcurrency TrafficUnit = "Concurrency"
+	// TrafficUnitRPS measures traffic in requests per second.
+	// The service is scaled on Knative's rps metric, profile targets are read as requests per second per pod.
+	TrafficUnitRPS TrafficUnit = "RPS"
+)
+
+// PredictedTraffic is a traffic prediction for the target
+type PredictedTraffic struct {
+	// Value is the predicted traffic, e.g. 100 or 12.5. It must not be negative.
+	Value resource.Quantity `json:"value"`
+
+	// Unit of Value.
+	// +kubebuilder:default=Concurrency
+	// +optional
+	Unit TrafficUnit `json:"unit,omitempty"`
+}
+
+// TrafficStatStatus defines the observed state of TrafficStat
+type TrafficStatStatus struct {
+	// ObservedGeneration is the TrafficStat generation the status was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Mode is how decisions currently reach the target.
+	// +optional
+	Mode ScalingMode `json:"mode,omitempty"`
+
+	// Conditions describe each step from prediction to running revision:
+	// ProfileFound, ServiceFound, DecisionComputed, FitsCapacity, ScaleWithinLimits, SwitchHeld, RevisionReady, RolloutFailed
+	// and OldRevisionCleaned.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// Decision is the resource-concurrency pair chosen for the predicted traffic.
+	// +optional
+	Decision *DecisionStatus `json:"decision,omitempty"`
+
+	// Rollout tracks the switch of the target service to a new resource-concurrency pair.
+	// It lets the controller resume an interrupted rollout after a restart.
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+
+	// LastSwitchTime is when the target last started switching to another resource-concurrency pair.
+	// The stabilization cooldown is counted from it.
+	// +optional
+	LastSwitchTime *metav1.Time `json:"lastSwitchTime,omitempty"`
+
+	// BlockedLevels are the resource levels whose rollout failed. They are not chosen again until their backoff expires.
+	// +optional
+	// +listType=atomic
+	BlockedLevels []BlockedLevel `json:"blockedLevels,omitempty"`
+
+	// Original is the part of the target's template the controller changed, as it was before the controller first wrote it.
+	// It is restored when the TrafficStat is deleted, and when spec.targetRef moves to another service.
+	// +optional
+	Original *TemplateSnapshot `json:"original,omitempty"`
+}
+
+// ScalingMode is how the decisions of a TrafficStat reach its target
+// +kubebuilder:validation:Enum=Predictive;Suspended;Override
+type ScalingMode string
+
+const (
+	// ScalingModePredictive applies the pair chosen for the predicted traffic.
+	ScalingModePredictive ScalingMode = "Predictive"
+	// ScalingModeSuspended computes and reports decisions without applying them.
+	ScalingModeSuspended ScalingMode = "Suspended"
+	// ScalingModeOverride applies the pair and pod count of spec.override until it expires.
+	ScalingModeOverride ScalingMode = "Override"
+)
+
+// Condition types reported in TrafficStatStatus.Conditions
+const (
+	// ConditionProfileFound is True when the hybrid profile of the target service was read.
+	ConditionProfileFound = "ProfileFound"
+	// ConditionServiceFound is True when the target Knative Service exists.
+	ConditionServiceFound = "ServiceFound"
+	// ConditionDecisionComputed is True when a resource-concurrency pair was chosen for the predicted traffic.
+	ConditionDecisionComputed = "DecisionComputed"
+	// ConditionFitsCapacity is True when the pods of the chosen pair fit on the nodes of the cluster.
+	// Its message lists the better ranked pairs that were discarded because they do not fit.
+	ConditionFitsCapacity = "FitsCapacity"
+	// ConditionScaleWithinLimits is True when the predicted pods are written as initial-scale and min-scale as they are,
+	// False when the service's max-scale or a ResourceQuota of its namespace lowered them.
+	ConditionScaleWithinLimits = "ScaleWithinLimits"
+	// ConditionSwitchHeld is True when the best ranked pair is held back by the stabilization policy
+	// and the current pair is kept.
+	ConditionSwitchHeld = "SwitchHeld"
+	// ConditionRevisionReady is True when the revision running the chosen pair is Ready.
+	ConditionRevisionReady = "RevisionReady"
+	// ConditionRolloutFailed is True when the last rollout did not complete and the previous template was restored.
+	ConditionRolloutFailed = "RolloutFailed"
+	// ConditionOldRevisionCleaned is True when the revision replaced by the last rollout was removed.
+	ConditionOldRevisionCleaned = "OldRevisionCleaned"
+)
+
+// DecisionStatus is the resource-concurrency pair chosen for the predicted traffic
+type DecisionStatus struct {
+	// ResourceLevel is the chosen level of the service's intensive resource, e.g. 1500m or 512Mi.
+	// +optional
+	ResourceLevel string `json:"resourceLevel,omitempty"`
+
+	// Concurrency is the chosen autoscaling.knative.dev/target value.
+	// +optional
+	Concurrency string `json:"concurrency,omitempty"`
+
+	// ExpectedPods is the number of pods needed to serve the predicted traffic with the chosen pair.
+	// +optional
+	ExpectedPods int32 `json:"expectedPods,omitempty"`
+
+	// ExpectedTotalResources is ExpectedPods times ResourceLevel.
+	// +optional
+	ExpectedTotalResources string `json:"expectedTotalResources,omitempty"`
+
+	// AppliedRevision is the revision that runs the chosen pair, empty until it is rolled out.
+	// +optional
+	AppliedRevision string `json:"appliedRevision,omitempty"`
+
+	// DecisionTime is when the chosen pair last changed.
+	// +optional
+	DecisionTime *metav1.Time `json:"decisionTime,omitempty"`
+
+	// AppliedTime is when AppliedRevision became Ready.
+	// +optional
+	AppliedTime *metav1.Time `json:"appliedTime,omitempty"`
+
+	// Scale is the pod count written for the chosen pair and the limits it was checked against.
+	// +optional
+	Scale *ScaleStatus `json:"scale,omitempty"`
+
+	// Candidates are the best ranked pairs, best first, as the optimizer compared them.
+	// +optional
+	// +listType=atomic
+	Candidates []CandidateStatus `json:"candidates,omitempty"`
+}
+
+// ScaleLimit is what lowered the pod count of a decision
+// +kubebuilder:validation:Enum=MaxScale;ResourceQuota
+type ScaleLimit string
+
+const (
+	// ScaleLimitMaxScale means the autoscaling.knative.dev/max-scale of the service is below the predicted pods.
+	ScaleLimitMaxScale ScaleLimit = "MaxScale"
+	// ScaleLimitResourceQuota means a ResourceQuota of the service's namespace has no room for the predicted pods.
+	ScaleLimitResourceQuota ScaleLimit = "ResourceQuota"
+)
+
+// ScaleStatus is the pod count of a decision
+type ScaleStatus struct {
+	// Predicted is the number of pods the chosen pair needs for the predicted traffic.
+	Predicted int32 `json:"predicted"`
+
+	// Applied is the initial-scale and min-scale value of the pair, Predicted unless LimitedBy lowered it.
+	Applied int32 `json:"applied"`
+
+	// MaxScale is the max-scale of the service, unset when it is not bounded.
+	// +optional
+	MaxScale *int32 `json:"maxScale,omitempty"`
+
+	// QuotaPods is how many pods of the pair the ResourceQuotas of the namespace have room for,
+	// unset when no quota bounds them.
+	// +optional
+	QuotaPods *int32 `json:"quotaPods,omitempty"`
+
+	// LimitedBy is the limit that lowered Applied.
+	// +optional
+	LimitedBy ScaleLimit `json:"limitedBy,omitempty"`
+
+	// Limiting is the quota, and its resource, that gave QuotaPods.
+	// +optional
+	Limiting string `json:"limiting,omitempty"`
+}
+
+// CandidateStatus is a ranked resource-concurrency pair
+type CandidateStatus struct {
+	// ResourceLevel is the level of the service's intensive resource, or the resource list of the pair.
+	ResourceLevel string `json:"resourceLevel"`
+
+	// Concurrency is the autoscaling.knative.dev/target value of the pair.
+	Concurrency string `json:"concurrency"`
+
+	// ExpectedPods is the number of pods needed to serve the predicted traffic with the pair.
+	ExpectedPods int32 `json:"expectedPods"`
+
+	// ExpectedTotalResources is ExpectedPods times ResourceLevel.
+	// +optional
+	ExpectedTotalResources string `json:"expectedTotalResources,omitempty"`
+
+	// Cost is the total resources weighed with the profile's objective, which the optimizer compares.
+	// +optional
+	Cost string `json:"cost,omitempty"`
+}
+
+// RolloutPhase is a step of the switch of a service to a new resource-concurrency pair
+// +kubebuilder:validation:Enum=Applying;WaitingForRevision;Shifting;Draining;RollingBack;Done;Failed
+type RolloutPhase string
+
+const (
+	// RolloutPhaseApplying means the chosen pair is being written to the Knative Service.
+	RolloutPhaseApplying RolloutPhase = "Applying"
+	// RolloutPhaseWaitingForRevision means the controller waits for the new revision to serve.
+	RolloutPhaseWaitingForRevision RolloutPhase = "WaitingForRevision"
+	// RolloutPhaseShifting means traffic moves from the previous revision to the new one in steps.
+	RolloutPhaseShifting RolloutPhase = "Shifting"
+	// RolloutPhaseDraining means the previous revision and its pods are being removed.
+	RolloutPhaseDraining RolloutPhase = "Draining"
+	// RolloutPhaseRollingBack means the new revision failed and the previous template is being restored.
+	RolloutPhaseRollingBack RolloutPhase = "RollingBack"
+	// RolloutPhaseDone means the service runs with the chosen pair.
+	RolloutPhaseDone RolloutPhase = "Done"
+	// RolloutPhaseFailed means the new revision did not become ready, see Reason and Message.
+	// The previous template was restored.
+	RolloutPhaseFailed RolloutPhase = "Failed"
+)
+
+// HybridPair is a resource-concurrency pair and the number of pods it is applied with
+type HybridPair struct {
+	// Resources are the container requests and limits of the pair.
+	// +optional
+	Resources corev1.ResourceList `json:"resources,omitempty"`
+
+	// Concurrency is the autoscaling.knative.dev/target value of the pair.
+	// +optional
+	Concurrency string `json:"concurrency,omitempty"`
+
+	// NumberOfPods is the initial-scale and min-scale value of the pair.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	NumberOfPods int32 `json:"numberOfPods,omitempty"`
+
+	// Unit is the traffic unit of Concurrency, it selects the Knative autoscaling metric.
+	// +optional
+	Unit TrafficUnit `json:"unit,omitempty"`
+
+	// Annotations are the autoscaling annotations managed by the AutoscalingPolicy.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// TemplateSnapshot is the part of a Knative Service revision template a rollout changes
+type TemplateSnapshot struct {
+	// Annotations are the autoscaling annotations of the template. An empty value means the annotation was not set.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Resources are the requests and limits of the serving container.
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// ResourceNames are the resources whose requests and limits were recorded in Resources, the only ones restored.
+	// When empty, Resources replace all requests and limits of the container.
+	// +optional
+	// +listType=atomic
+	ResourceNames []corev1.ResourceName `json:"resourceNames,omitempty"`
+
+	// Service is the namespace/name of the Knative Service the snapshot was taken from. It is recorded in
+	// status.original, which is only restored onto that service.
+	// +optional
+	Service string `json:"service,omitempty"`
+}
+
+// BlockedLevel is a resource level left out of the decisions after its rollout failed
+type BlockedLevel struct {
+	// Resources are the requests and limits of a pod of the level.
+	Resources corev1.ResourceList `json:"resources"`
+
+	// Until is when the level may be chosen again.
+	Until metav1.Time `json:"until"`
+
+	// Reason is why the rollout of the level failed.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+}
+
+// RolloutStatus describes the rollout of a hybrid pair to the target service
+type RolloutStatus struct {
+	// Phase is the current step of the rollout.
+	Phase RolloutPhase `json:"phase"`
+
+	// Pair is the resource-concurrency pair being rolled out.
+	Pair HybridPair `json:"pair"`
+
+	// PreviousRevision is the revision that served before the rollout started.
+	// +optional
+	PreviousRevision string `json:"previousRevision,omitempty"`
+
+	// ServiceGeneration is the generation of the Knative Service once Pair was applied.
+	// The revision Knative creates for that generation is the one the rollout waits for.
+	// +optional
+	ServiceGeneration int64 `json:"serviceGeneration,omitempty"`
+
+	// NewRevision is the revision created for Pair, as reported in the Service's latestCreatedRevisionName.
+	// +optional
+	NewRevision string `json:"newRevision,omitempty"`
+
+	// Strategy is how traffic moves to NewRevision.
+	// +optional
+	Strategy RolloutStrategy `json:"strategy,omitempty"`
+
+	// Steps are the traffic percentages routed to NewRevision in turn by the Gradual strategy.
+	// +optional
+	Steps []int32 `json:"steps,omitempty"`
+
+	// TrafficPercent is the share of traffic routed to NewRevision by the Gradual strategy.
+	// +optional
+	TrafficPercent int32 `json:"trafficPercent,omitempty"`
+
+	// StepInterval is the minimum time each of Steps serves.
+	// +optional
+	StepInterval *metav1.Duration `json:"stepInterval,omitempty"`
+
+	// KnownGood is the part of the template Pair changes, as it was before the rollout.
+	// It is restored when the rollout fails.
+	// +optional
+	KnownGood *TemplateSnapshot `json:"knownGood,omitempty"`
+
+	// Reason is a CamelCase reason for the last phase transition, set when the rollout failed.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message is a human readable explanation of Reason.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastTransitionTime is when Phase last changed.
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.targetRef.name`
+//+kubebuilder:printcolumn:name="Traffic",type=string,JSONPath=`.spec.traffic.value`
+//+kubebuilder:printcolumn:name="Unit",type=string,JSONPath=`.spec.traffic.unit`,priority=1
+//+kubebuilder:printcolumn:name="Resources",type=string,JSONPath=`.status.decision.resourceLevel`
+//+kubebuilder:printcolumn:name="Concurrency",type=string,JSONPath=`.status.decision.concurrency`
+//+kubebuilder:printcolumn:name="Pods",type=integer,JSONPath=`.status.decision.expectedPods`
+//+kubebuilder:printcolumn:name="Revision",type=string,JSONPath=`.status.decision.appliedRevision`
+//+kubebuilder:printcolumn:name="Rollout",type=string,JSONPath=`.status.rollout.phase`
+//+kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.status.mode`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TrafficStat is the Schema for the trafficstats API
+type TrafficStat struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TrafficStatSpec   `json:"spec,omitempty"`
+	Status TrafficStatStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TrafficStatList contains a list of TrafficStat
+type TrafficStatList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TrafficStat `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TrafficStat{}, &TrafficStatList{})
+}
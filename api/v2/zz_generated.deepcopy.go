@@ -0,0 +1,784 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AutoscalingPolicy) DeepCopyInto(out *AutoscalingPolicy) {
+	*out = *in
+	if in.Managed != nil {
+		in, out := &in.Managed, &out.Managed
+		*out = make([]ManagedAnnotation, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxScaleHeadroomPercentage != nil {
+		in, out := &in.MaxScaleHeadroomPercentage, &out.MaxScaleHeadroomPercentage
+		*out = new(int32)
+		**out = **in
+	}
+	if in.TargetBurstCapacity != nil {
+		in, out := &in.TargetBurstCapacity, &out.TargetBurstCapacity
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PanicWindowPercentage != nil {
+		in, out := &in.PanicWindowPercentage, &out.PanicWindowPercentage
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ScaleDownDelay != nil {
+		in, out := &in.ScaleDownDelay, &out.ScaleDownDelay
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoscalingPolicy.
+func (in *AutoscalingPolicy) DeepCopy() *AutoscalingPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(AutoscalingPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlockedLevel) DeepCopyInto(out *BlockedLevel) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	in.Until.DeepCopyInto(&out.Until)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlockedLevel.
+func (in *BlockedLevel) DeepCopy() *BlockedLevel {
+	if in == nil {
+		return nil
+	}
+	out := new(BlockedLevel)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CandidateStatus) DeepCopyInto(out *CandidateStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CandidateStatus.
+func (in *CandidateStatus) DeepCopy() *CandidateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CandidateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DecisionStatus) DeepCopyInto(out *DecisionStatus) {
+	*out = *in
+	if in.DecisionTime != nil {
+		in, out := &in.DecisionTime, &out.DecisionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.AppliedTime != nil {
+		in, out := &in.AppliedTime, &out.AppliedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Scale != nil {
+		in, out := &in.Scale, &out.Scale
+		*out = new(ScaleStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Candidates != nil {
+		in, out := &in.Candidates, &out.Candidates
+		*out = make([]CandidateStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DecisionStatus.
+func (in *DecisionStatus) DeepCopy() *DecisionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DecisionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeletionPolicy) DeepCopyInto(out *DeletionPolicy) {
+	*out = *in
+	if in.Baseline != nil {
+		in, out := &in.Baseline, &out.Baseline
+		*out = new(TemplateSnapshot)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeletionPolicy.
+func (in *DeletionPolicy) DeepCopy() *DeletionPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(DeletionPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FallbackPolicy) DeepCopyInto(out *FallbackPolicy) {
+	*out = *in
+	if in.Concurrency != nil {
+		in, out := &in.Concurrency, &out.Concurrency
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FallbackPolicy.
+func (in *FallbackPolicy) DeepCopy() *FallbackPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(FallbackPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HybridPair) DeepCopyInto(out *HybridPair) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HybridPair.
+func (in *HybridPair) DeepCopy() *HybridPair {
+	if in == nil {
+		return nil
+	}
+	out := new(HybridPair)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HybridScalingProfile) DeepCopyInto(out *HybridScalingProfile) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HybridScalingProfile.
+func (in *HybridScalingProfile) DeepCopy() *HybridScalingProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(HybridScalingProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *HybridScalingProfile) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HybridScalingProfileList) DeepCopyInto(out *HybridScalingProfileList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]HybridScalingProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HybridScalingProfileList.
+func (in *HybridScalingProfileList) DeepCopy() *HybridScalingProfileList {
+	if in == nil {
+		return nil
+	}
+	out := new(HybridScalingProfileList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *HybridScalingProfileList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HybridScalingProfileSpec) DeepCopyInto(out *HybridScalingProfileSpec) {
+	*out = *in
+	out.MeasuredFor = in.MeasuredFor
+	if in.FixedResources != nil {
+		in, out := &in.FixedResources, &out.FixedResources
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Entries != nil {
+		in, out := &in.Entries, &out.Entries
+		*out = make([]ProfileEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TargetUtilizationPercentage != nil {
+		in, out := &in.TargetUtilizationPercentage, &out.TargetUtilizationPercentage
+		*out = new(int32)
+		**out = **in
+	}
+	if in.AutoscalingPolicy != nil {
+		in, out := &in.AutoscalingPolicy, &out.AutoscalingPolicy
+		*out = new(AutoscalingPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Stabilization != nil {
+		in, out := &in.Stabilization, &out.Stabilization
+		*out = new(StabilizationPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RolloutPolicy != nil {
+		in, out := &in.RolloutPolicy, &out.RolloutPolicy
+		*out = new(RolloutPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Objective != nil {
+		in, out := &in.Objective, &out.Objective
+		*out = new(Objective)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TieBreak != nil {
+		in, out := &in.TieBreak, &out.TieBreak
+		*out = make([]TieBreaker, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HybridScalingProfileSpec.
+func (in *HybridScalingProfileSpec) DeepCopy() *HybridScalingProfileSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HybridScalingProfileSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Objective) DeepCopyInto(out *Objective) {
+	*out = *in
+	if in.Prices != nil {
+		in, out := &in.Prices, &out.Prices
+		*out = make([]ResourcePrice, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Objective.
+func (in *Objective) DeepCopy() *Objective {
+	if in == nil {
+		return nil
+	}
+	out := new(Objective)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PredictedTraffic) DeepCopyInto(out *PredictedTraffic) {
+	*out = *in
+	out.Value = in.Value.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PredictedTraffic.
+func (in *PredictedTraffic) DeepCopy() *PredictedTraffic {
+	if in == nil {
+		return nil
+	}
+	out := new(PredictedTraffic)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProfileEntry) DeepCopyInto(out *ProfileEntry) {
+	*out = *in
+	out.Resources = in.Resources.DeepCopy()
+	if in.ResourceList != nil {
+		in, out := &in.ResourceList, &out.ResourceList
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.LatencySLO != nil {
+		in, out := &in.LatencySLO, &out.LatencySLO
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProfileEntry.
+func (in *ProfileEntry) DeepCopy() *ProfileEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(ProfileEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProfileSubject) DeepCopyInto(out *ProfileSubject) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProfileSubject.
+func (in *ProfileSubject) DeepCopy() *ProfileSubject {
+	if in == nil {
+		return nil
+	}
+	out := new(ProfileSubject)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourcePrice) DeepCopyInto(out *ResourcePrice) {
+	*out = *in
+	if in.Unit != nil {
+		in, out := &in.Unit, &out.Unit
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	out.Price = in.Price.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourcePrice.
+func (in *ResourcePrice) DeepCopy() *ResourcePrice {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourcePrice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutPolicy) DeepCopyInto(out *RolloutPolicy) {
+	*out = *in
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.StepInterval != nil {
+		in, out := &in.StepInterval, &out.StepInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Deadline != nil {
+		in, out := &in.Deadline, &out.Deadline
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.FailureBackoff != nil {
+		in, out := &in.FailureBackoff, &out.FailureBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutPolicy.
+func (in *RolloutPolicy) DeepCopy() *RolloutPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutStatus) DeepCopyInto(out *RolloutStatus) {
+	*out = *in
+	in.Pair.DeepCopyInto(&out.Pair)
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.StepInterval != nil {
+		in, out := &in.StepInterval, &out.StepInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.KnownGood != nil {
+		in, out := &in.KnownGood, &out.KnownGood
+		*out = new(TemplateSnapshot)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastTransitionTime != nil {
+		in, out := &in.LastTransitionTime, &out.LastTransitionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutStatus.
+func (in *RolloutStatus) DeepCopy() *RolloutStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScaleStatus) DeepCopyInto(out *ScaleStatus) {
+	*out = *in
+	if in.MaxScale != nil {
+		in, out := &in.MaxScale, &out.MaxScale
+		*out = new(int32)
+		**out = **in
+	}
+	if in.QuotaPods != nil {
+		in, out := &in.QuotaPods, &out.QuotaPods
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScaleStatus.
+func (in *ScaleStatus) DeepCopy() *ScaleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScaleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScalingOverride) DeepCopyInto(out *ScalingOverride) {
+	*out = *in
+	out.ResourceLevel = in.ResourceLevel.DeepCopy()
+	if in.ResourceList != nil {
+		in, out := &in.ResourceList, &out.ResourceList
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	in.Until.DeepCopyInto(&out.Until)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingOverride.
+func (in *ScalingOverride) DeepCopy() *ScalingOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(ScalingOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StabilizationPolicy) DeepCopyInto(out *StabilizationPolicy) {
+	*out = *in
+	if in.Cooldown != nil {
+		in, out := &in.Cooldown, &out.Cooldown
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MinImprovementPercentage != nil {
+		in, out := &in.MinImprovementPercentage, &out.MinImprovementPercentage
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MinScaleTolerancePercentage != nil {
+		in, out := &in.MinScaleTolerancePercentage, &out.MinScaleTolerancePercentage
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StabilizationPolicy.
+func (in *StabilizationPolicy) DeepCopy() *StabilizationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(StabilizationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetReference) DeepCopyInto(out *TargetReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetReference.
+func (in *TargetReference) DeepCopy() *TargetReference {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplateSnapshot) DeepCopyInto(out *TemplateSnapshot) {
+	*out = *in
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.ResourceNames != nil {
+		in, out := &in.ResourceNames, &out.ResourceNames
+		*out = make([]corev1.ResourceName, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplateSnapshot.
+func (in *TemplateSnapshot) DeepCopy() *TemplateSnapshot {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplateSnapshot)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficStat) DeepCopyInto(out *TrafficStat) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficStat.
+func (in *TrafficStat) DeepCopy() *TrafficStat {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficStat)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TrafficStat) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficStatList) DeepCopyInto(out *TrafficStatList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TrafficStat, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficStatList.
+func (in *TrafficStatList) DeepCopy() *TrafficStatList {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficStatList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TrafficStatList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficStatSpec) DeepCopyInto(out *TrafficStatSpec) {
+	*out = *in
+	out.TargetRef = in.TargetRef
+	in.Traffic.DeepCopyInto(&out.Traffic)
+	if in.ProfileRef != nil {
+		in, out := &in.ProfileRef, &out.ProfileRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.TieBreak != nil {
+		in, out := &in.TieBreak, &out.TieBreak
+		*out = make([]TieBreaker, len(*in))
+		copy(*out, *in)
+	}
+	if in.AutoscalingPolicy != nil {
+		in, out := &in.AutoscalingPolicy, &out.AutoscalingPolicy
+		*out = new(AutoscalingPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Stabilization != nil {
+		in, out := &in.Stabilization, &out.Stabilization
+		*out = new(StabilizationPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RolloutPolicy != nil {
+		in, out := &in.RolloutPolicy, &out.RolloutPolicy
+		*out = new(RolloutPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Fallback != nil {
+		in, out := &in.Fallback, &out.Fallback
+		*out = new(FallbackPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OnDelete != nil {
+		in, out := &in.OnDelete, &out.OnDelete
+		*out = new(DeletionPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Override != nil {
+		in, out := &in.Override, &out.Override
+		*out = new(ScalingOverride)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficStatSpec.
+func (in *TrafficStatSpec) DeepCopy() *TrafficStatSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficStatSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficStatStatus) DeepCopyInto(out *TrafficStatStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Decision != nil {
+		in, out := &in.Decision, &out.Decision
+		*out = new(DecisionStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(RolloutStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastSwitchTime != nil {
+		in, out := &in.LastSwitchTime, &out.LastSwitchTime
+		*out = (*in).DeepCopy()
+	}
+	if in.BlockedLevels != nil {
+		in, out := &in.BlockedLevels, &out.BlockedLevels
+		*out = make([]BlockedLevel, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Original != nil {
+		in, out := &in.Original, &out.Original
+		*out = new(TemplateSnapshot)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficStatStatus.
+func (in *TrafficStatStatus) DeepCopy() *TrafficStatStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficStatStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"math"
+	"strconv"
+
+	"knative.dev/serving/pkg/apis/autoscaling"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+const (
+	// defaultMaxScaleHeadroomPercentage is added to the predicted pods (and concurrency) when the policy does not say otherwise.
+	defaultMaxScaleHeadroomPercentage = 20
+	// defaultPanicWindowPercentage is Knative's default panic-window-percentage.
+	defaultPanicWindowPercentage = 10
+)
+
+// autoscalingPolicy returns the AutoscalingPolicy of the TrafficStat, else of its profile.
+func autoscalingPolicy(ts *hybridscalingv2.TrafficStat, profile *hybridscalingv2.HybridScalingProfileSpec) *hybridscalingv2.AutoscalingPolicy {
+	if ts.Spec.AutoscalingPolicy != nil {
+		return ts.Spec.AutoscalingPolicy
+	}
+	return profile.AutoscalingPolicy
+}
+
+// managedAnnotations derives the annotations managed by the policy from the decision:
+// the target utilization it was computed with, the predicted traffic and the predicted pods.
+func managedAnnotations(policy *hybridscalingv2.AutoscalingPolicy, utilization, traffic float64, pods int32, unit hybridscalingv2.TrafficUnit) map[string]string {
+	if policy == nil || len(policy.Managed) == 0 {
+		return nil
+	}
+	headroom := float64(defaultMaxScaleHeadroomPercentage)
+	if policy.MaxScaleHeadroomPercentage != nil {
+		headroom = float64(*policy.MaxScaleHeadroomPercentage)
+	}
+
+	annotations := map[string]string{}
+	if policy.Manages(hybridscalingv2.ManagedTargetUtilization) {
+		annotations[autoscaling.TargetUtilizationPercentageKey] = strconv.FormatFloat(utilization*100, 'f', -1, 64)
+	}
+	if policy.Manages(hybridscalingv2.ManagedMaxScale) {
+		// No pods predicted gives 0, which Knative reads as no ceiling
+		maxScale := int64(math.Ceil(float64(pods) * (1 + headroom/100)))
+		annotations[autoscaling.MaxScaleAnnotationKey] = strconv.FormatInt(maxScale, 10)
+	}
+	if policy.Manages(hybridscalingv2.ManagedTargetBurstCapacity) {
+		switch {
+		case policy.TargetBurstCapacity != nil:
+			annotations[autoscaling.TargetBurstCapacityKey] = strconv.Itoa(int(*policy.TargetBurstCapacity))
+		case unit != hybridscalingv2.TrafficUnitRPS:
+			// Burst capacity is counted in concurrent requests, it is only derived from concurrency predictions
+			annotations[autoscaling.TargetBurstCapacityKey] = strconv.FormatFloat(math.Ceil(traffic*headroom/100), 'f', -1, 64)
+		}
+	}
+	if policy.Manages(hybridscalingv2.ManagedPanicWindowPercentage) {
+		panicWindow := int32(defaultPanicWindowPercentage)
+		if policy.PanicWindowPercentage != nil {
+			panicWindow = *policy.PanicWindowPercentage
+		}
+		annotations[autoscaling.PanicWindowPercentageAnnotationKey] = strconv.Itoa(int(panicWindow))
+	}
+	if policy.Manages(hybridscalingv2.ManagedScaleDownDelay) {
+		scaleDownDelay := "0s"
+		if policy.ScaleDownDelay != nil {
+			scaleDownDelay = policy.ScaleDownDelay.Duration.String()
+		}
+		annotations[autoscaling.ScaleDownDelayAnnotationKey] = scaleDownDelay
+	}
+	return annotations
+}
+
+// annotationsMatch reports whether every wanted annotation is set to its value.
+func annotationsMatch(current, wanted map[string]string) bool {
+	for k, v := range wanted {
+		if current[k] != v {
+			return false
+		}
+	}
+	return true
+}
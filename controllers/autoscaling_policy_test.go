@@ -0,0 +1,56 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestManagedAnnotations(t *testing.T) {
+	panicWindow := int32(20)
+	policy := &hybridscalingv2.AutoscalingPolicy{
+		Managed: []hybridscalingv2.ManagedAnnotation{
+			hybridscalingv2.ManagedTargetUtilization,
+			hybridscalingv2.ManagedMaxScale,
+			hybridscalingv2.ManagedTargetBurstCapacity,
+			hybridscalingv2.ManagedPanicWindowPercentage,
+			hybridscalingv2.ManagedScaleDownDelay,
+		},
+		PanicWindowPercentage: &panicWindow,
+		ScaleDownDelay:        &metav1.Duration{Duration: time.Minute},
+	}
+	want := map[string]string{
+		"autoscaling.knative.dev/target-utilization-percentage": "70",
+		"autoscaling.knative.dev/max-scale":                     "12",
+		"autoscaling.knative.dev/target-burst-capacity":         "20",
+		"autoscaling.knative.dev/panic-window-percentage":       "20",
+		"autoscaling.knative.dev/scale-down-delay":              "1m0s",
+	}
+	// 100 concurrent requests on 10 pods, with the default 20% headroom
+	if got := managedAnnotations(policy, 0.7, 100, 10, hybridscalingv2.TrafficUnitConcurrency); !reflect.DeepEqual(got, want) {
+		t.Errorf("managedAnnotations() = %v, want %v", got, want)
+	}
+	if got := managedAnnotations(nil, 0.7, 100, 10, hybridscalingv2.TrafficUnitConcurrency); got != nil {
+		t.Errorf("managedAnnotations() without policy = %v, want none", got)
+	}
+}
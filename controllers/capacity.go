@@ -0,0 +1,281 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+	"knative.dev/serving/pkg/deployment"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+	"github.com/mipearlska/knative_hybrid_scaling/pkg/optimizer"
+)
+
+// podNodeIndex indexes pods by the node they are bound to.
+const podNodeIndex = ".spec.nodeName"
+
+// indexPodNode returns the podNodeIndex value of a pod, none before it is scheduled.
+func indexPodNode(obj client.Object) []string {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil
+	}
+	return []string{pod.Spec.NodeName}
+}
+
+// clusterState is what the capacity check and the NodeShape objective read from the cluster.
+type clusterState struct {
+	nodes []corev1.Node
+	pods  []corev1.Pod
+}
+
+// readCluster reads the nodes and the pods that hold resources on the schedulable ones from the cache.
+// It returns nil when the capacity check is off.
+func (r *TrafficStatReconciler) readCluster(ctx context.Context) (*clusterState, error) {
+	if !r.CheckCapacity {
+		return nil, nil
+	}
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return nil, err
+	}
+	cluster := &clusterState{nodes: nodes.Items}
+	for i := range nodes.Items {
+		if !schedulable(&nodes.Items[i]) {
+			continue
+		}
+		pods := &corev1.PodList{}
+		if err := r.List(ctx, pods, client.MatchingFields{podNodeIndex: nodes.Items[i].Name}); err != nil {
+			return nil, err
+		}
+		for _, pod := range pods.Items {
+			// Pods that ended hold no resources
+			if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+				cluster.pods = append(cluster.pods, pod)
+			}
+		}
+	}
+	return cluster, nil
+}
+
+// nodeShape returns the largest allocatable amount of each resource on a schedulable node.
+func nodeShape(nodes []corev1.Node) corev1.ResourceList {
+	shape := corev1.ResourceList{}
+	for i := range nodes {
+		if !schedulable(&nodes[i]) {
+			continue
+		}
+		for name, allocatable := range nodes[i].Status.Allocatable {
+			if largest, ok := shape[name]; !ok || allocatable.Cmp(largest) > 0 {
+				shape[name] = allocatable.DeepCopy()
+			}
+		}
+	}
+	return shape
+}
+
+// feasibleDecisions drops the decisions whose pods do not fit on the nodes of the cluster and reports
+// in the FitsCapacity condition which better ranked pairs were discarded. New pairs have to fit next to
+// the pods of the running revision, which serve until the rollout is done; the running pair only needs
+// to fit once its own pods are given back. Without a cluster state, every decision is kept.
+func feasibleDecisions(ts *hybridscalingv2.TrafficStat, svc *servingv1.Service, cluster *clusterState, decisions []optimizer.Decision, current *optimizer.Decision) []optimizer.Decision {
+	if cluster == nil {
+		return decisions
+	}
+	withRunningRevision := nodeCapacities(cluster.nodes, cluster.pods, "")
+	withoutRunningRevision := nodeCapacities(cluster.nodes, cluster.pods, svc.Status.LatestReadyRevisionName)
+	sidecars := sidecarRequests(svc, cluster.pods)
+
+	feasible := make([]optimizer.Decision, 0, len(decisions))
+	var discarded []string
+	for i := range decisions {
+		capacity := withRunningRevision
+		if current != nil && samePair(&decisions[i], current) {
+			capacity = withoutRunningRevision
+		}
+		if optimizer.Fits(capacity, podSize(svc, sidecars, decisions[i].Resources), decisions[i].Pods) {
+			feasible = append(feasible, decisions[i])
+			continue
+		}
+		loggerSD.Info("CR Pair does not fit on the nodes", "CR_PAIR", fmt.Sprintf("%s/%d", describeLevel(&decisions[i]), decisions[i].Entry.OptimalConcurrency), "EX_NUMBER_OF_PODS", decisions[i].Pods)
+		if len(feasible) == 0 {
+			discarded = append(discarded, fmt.Sprintf("%d pods of %s", decisions[i].Pods, describeLevel(&decisions[i])))
+		}
+	}
+
+	switch {
+	case len(feasible) == 0:
+		setCondition(ts, hybridscalingv2.ConditionFitsCapacity, metav1.ConditionFalse, "InsufficientCapacity",
+			"no pair fits on the nodes: "+strings.Join(discarded, ", "))
+	case len(discarded) > 0:
+		setCondition(ts, hybridscalingv2.ConditionFitsCapacity, metav1.ConditionTrue, "BetterPairsDoNotFit",
+			"better ranked pairs do not fit on the nodes: "+strings.Join(discarded, ", "))
+	default:
+		setCondition(ts, hybridscalingv2.ConditionFitsCapacity, metav1.ConditionTrue, "Fits", "the best ranked pair fits on the nodes")
+	}
+	return feasible
+}
+
+// nodeCapacities returns the free capacity of the nodes new pods can be scheduled on: Ready, schedulable and
+// without NoSchedule or NoExecute taint. The requests of the pods bound to them are taken off their allocatable
+// resources, except for the pods of the excluded revision.
+func nodeCapacities(nodes []corev1.Node, pods []corev1.Pod, excludedRevision string) []optimizer.NodeCapacity {
+	free := map[string]corev1.ResourceList{}
+	var names []string
+	for i := range nodes {
+		node := &nodes[i]
+		if !schedulable(node) {
+			continue
+		}
+		free[node.Name] = node.Status.Allocatable.DeepCopy()
+		names = append(names, node.Name)
+	}
+	for i := range pods {
+		pod := &pods[i]
+		nodeFree, ok := free[pod.Spec.NodeName]
+		if !ok || (excludedRevision != "" && pod.Labels[serving.RevisionLabelKey] == excludedRevision) {
+			continue
+		}
+		for name, request := range podRequests(pod) {
+			if allocatable, ok := nodeFree[name]; ok {
+				allocatable.Sub(request)
+				nodeFree[name] = allocatable
+			}
+		}
+	}
+
+	capacities := make([]optimizer.NodeCapacity, 0, len(names))
+	for _, name := range names {
+		capacities = append(capacities, optimizer.NodeCapacity{Name: name, Free: free[name]})
+	}
+	return capacities
+}
+
+// schedulable reports whether new pods can be scheduled on the node.
+func schedulable(node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			return false
+		}
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podRequests returns the resources the scheduler reserves for the pod: the sum of its container requests,
+// or the largest init container request when that is higher, plus the pod overhead.
+func podRequests(pod *corev1.Pod) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			total := requests[name]
+			total.Add(quantity)
+			requests[name] = total
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if total, ok := requests[name]; !ok || quantity.Cmp(total) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	for name, quantity := range pod.Spec.Overhead {
+		total := requests[name]
+		total.Add(quantity)
+		requests[name] = total
+	}
+	return requests
+}
+
+// podSize returns the requests of a pod of the service with the given pair resources: those of the serving
+// container with the pair applied, plus what the other containers, the queue-proxy sidecar and the pod overhead add.
+func podSize(svc *servingv1.Service, sidecars, resources corev1.ResourceList) corev1.ResourceList {
+	size := corev1.ResourceList{}
+	if container := servingContainer(&svc.Spec.Template.Spec); container != nil {
+		size = container.Resources.Requests.DeepCopy()
+	}
+	for name, quantity := range resources {
+		size[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range sidecars {
+		total := size[name]
+		total.Add(quantity)
+		size[name] = total
+	}
+	return size
+}
+
+// sidecarRequests returns what a pod of the service requests besides its serving container. It is read from a pod
+// of the running revision, whose containers come in the order of the template followed by the queue-proxy sidecar.
+// Before the first pod runs, it is the other containers of the template and Knative's default queue-proxy request.
+func sidecarRequests(svc *servingv1.Service, pods []corev1.Pod) corev1.ResourceList {
+	containers := svc.Spec.Template.Spec.Containers
+	servingIndex := -1
+	if container := servingContainer(&svc.Spec.Template.Spec); container != nil {
+		for i := range containers {
+			if &containers[i] == container {
+				servingIndex = i
+			}
+		}
+	}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Namespace != svc.Namespace || svc.Status.LatestReadyRevisionName == "" ||
+			pod.Labels[serving.RevisionLabelKey] != svc.Status.LatestReadyRevisionName {
+			continue
+		}
+		requests := podRequests(pod)
+		if servingIndex >= 0 && servingIndex < len(pod.Spec.Containers) {
+			for name, quantity := range pod.Spec.Containers[servingIndex].Resources.Requests {
+				if total, ok := requests[name]; ok {
+					total.Sub(quantity)
+					requests[name] = total
+				}
+			}
+		}
+		return requests
+	}
+
+	requests := corev1.ResourceList{corev1.ResourceCPU: deployment.QueueSidecarCPURequestDefault.DeepCopy()}
+	for i := range containers {
+		if i == servingIndex {
+			continue
+		}
+		for name, quantity := range containers[i].Resources.Requests {
+			total := requests[name]
+			total.Add(quantity)
+			requests[name] = total
+		}
+	}
+	return requests
+}
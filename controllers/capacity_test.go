@@ -0,0 +1,139 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+func TestNodeCapacities(t *testing.T) {
+	ready := corev1.NodeStatus{
+		Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+		Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+	}
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "edge-1"}, Status: ready},
+		{ObjectMeta: metav1.ObjectMeta{Name: "edge-2"}, Spec: corev1.NodeSpec{Unschedulable: true}, Status: ready},
+	}
+	pod := func(revision, cpu string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{serving.RevisionLabelKey: revision}},
+			Spec: corev1.PodSpec{NodeName: "edge-1", Containers: []corev1.Container{
+				{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}}},
+				{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("25m")}}},
+			}},
+		}
+	}
+	pods := []corev1.Pod{pod("svc-00001", "1500m"), pod("other-00001", "500m")}
+
+	capacities := nodeCapacities(nodes, pods, "")
+	if len(capacities) != 1 || capacities[0].Name != "edge-1" {
+		t.Fatalf("nodeCapacities() = %+v, want edge-1 only", capacities)
+	}
+	if free := capacities[0].Free[corev1.ResourceCPU]; free.Cmp(resource.MustParse("1950m")) != 0 {
+		t.Errorf("free cpu = %s, want 1950m", free.String())
+	}
+	without := nodeCapacities(nodes, pods, "svc-00001")
+	if free := without[0].Free[corev1.ResourceCPU]; free.Cmp(resource.MustParse("3475m")) != 0 {
+		t.Errorf("free cpu without svc-00001 = %s, want 3475m", free.String())
+	}
+}
+
+func TestReadCluster(t *testing.T) {
+	ready := corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}}
+	pod := func(name, node string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant-a"},
+			Spec: corev1.PodSpec{NodeName: node}, Status: corev1.PodStatus{Phase: phase}}
+	}
+	c := newTestClientBuilder(t).
+		WithIndex(&corev1.Pod{}, podNodeIndex, indexPodNode).
+		WithObjects(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "edge-1"}, Status: ready},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "edge-2"}, Spec: corev1.NodeSpec{Unschedulable: true}, Status: ready},
+			pod("running", "edge-1", corev1.PodRunning),
+			pod("succeeded", "edge-1", corev1.PodSucceeded),
+			pod("cordoned", "edge-2", corev1.PodRunning),
+			pod("pending", "", corev1.PodPending),
+		).Build()
+
+	if cluster, err := (&TrafficStatReconciler{Client: c}).readCluster(context.Background()); err != nil || cluster != nil {
+		t.Errorf("readCluster() without the capacity check = %v, %v, want nil", cluster, err)
+	}
+	cluster, err := (&TrafficStatReconciler{Client: c, CheckCapacity: true}).readCluster(context.Background())
+	if err != nil {
+		t.Fatalf("readCluster() error = %v", err)
+	}
+	if len(cluster.nodes) != 2 {
+		t.Errorf("readCluster() read %d nodes, want 2", len(cluster.nodes))
+	}
+	if len(cluster.pods) != 1 || cluster.pods[0].Name != "running" {
+		t.Errorf("readCluster() pods = %+v, want the running pod of edge-1 only", cluster.pods)
+	}
+}
+
+func TestPodSize(t *testing.T) {
+	cpu := func(quantity string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(quantity)}}
+	}
+	svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"}}
+	svc.Spec.Template.Spec.Containers = []corev1.Container{
+		{Name: "log-shipper", Resources: cpu("100m")},
+		{Name: "app", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}, Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("256Mi")}}},
+	}
+	svc.Status.LatestReadyRevisionName = "service-a-00001"
+	running := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Labels: map[string]string{serving.RevisionLabelKey: "service-a-00001"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "log-shipper", Resources: cpu("100m")},
+				{Name: "app", Resources: cpu("500m")},
+				{Name: "queue-proxy", Resources: cpu("50m")},
+			},
+			Overhead: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")},
+		},
+	}
+	pair := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")}
+
+	for _, tc := range []struct {
+		name       string
+		pods       []corev1.Pod
+		wantCPU    string
+		wantMemory string
+	}{
+		// The sidecars, the queue-proxy and the overhead of the running pod
+		{name: "running pod", pods: []corev1.Pod{running}, wantCPU: "1660m", wantMemory: "256Mi"},
+		// The other containers of the template and the default queue-proxy request
+		{name: "no pod", wantCPU: "1625m", wantMemory: "256Mi"},
+	} {
+		size := podSize(svc, sidecarRequests(svc, tc.pods), pair)
+		if got := size[corev1.ResourceCPU]; got.Cmp(resource.MustParse(tc.wantCPU)) != 0 {
+			t.Errorf("%s: pod cpu = %s, want %s", tc.name, got.String(), tc.wantCPU)
+		}
+		if got := size[corev1.ResourceMemory]; got.Cmp(resource.MustParse(tc.wantMemory)) != 0 {
+			t.Errorf("%s: pod memory = %s, want %s", tc.name, got.String(), tc.wantMemory)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+// cleanupPolicy returns how the previous revision is removed. It is left to Knative unless the policy opts in to another cleanup.
+func cleanupPolicy(policy *hybridscalingv2.RolloutPolicy) hybridscalingv2.CleanupPolicy {
+	if policy == nil || policy.Cleanup == "" {
+		return hybridscalingv2.CleanupKnativeGC
+	}
+	return policy.Cleanup
+}
+
+// cleanupRevision removes the previous revision as the cleanup policy says and records every action as an Event
+// on the TrafficStat. The pods are never evicted one by one, the ReplicaSet of a revision would recreate them:
+// the revision is deleted and the deletion cascades to its pods, which terminate with their grace period.
+// It reports false while the cleanup is in progress: held by a PodDisruptionBudget, or, for Drain, until the
+// revision and its pods are gone.
+func (r *TrafficStatReconciler) cleanupRevision(ctx context.Context, ts *hybridscalingv2.TrafficStat, namespace, revision string, cleanup hybridscalingv2.CleanupPolicy) (bool, error) {
+	if revision == "" || cleanup == hybridscalingv2.CleanupKnativeGC {
+		return true, nil
+	}
+
+	RevisionPodList := &corev1.PodList{}
+	if err := r.List(ctx, RevisionPodList, client.InNamespace(namespace), client.MatchingLabels{serving.RevisionLabelKey: revision}); err != nil {
+		return false, err
+	}
+	var running []corev1.Pod
+	for _, pod := range RevisionPodList.Items {
+		if pod.DeletionTimestamp.IsZero() {
+			running = append(running, pod)
+		}
+	}
+	if len(running) > 0 {
+		budget, err := r.blockingDisruptionBudget(ctx, namespace, running)
+		if err != nil {
+			return false, err
+		}
+		if budget != "" {
+			message := fmt.Sprintf("PodDisruptionBudget %s does not allow removing the %d pods of revision %s", budget, len(running), revision)
+			loggerSD.Info("Revision cleanup held by PodDisruptionBudget", "REVISION_NAME", revision, "PDB_NAME", budget)
+			// The Event is recorded once, the condition tells the cleanup is still held
+			if cond := meta.FindStatusCondition(ts.Status.Conditions, hybridscalingv2.ConditionOldRevisionCleaned); cond == nil || cond.Reason != "CleanupBlocked" {
+				r.event(ts, corev1.EventTypeWarning, "CleanupBlocked", "%s", message)
+			}
+			setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionFalse, "CleanupBlocked", message)
+			return false, nil
+		}
+	}
+
+	// Drain keeps the revision until its pods terminated, the other policies only start its deletion
+	var opts []client.DeleteOption
+	if cleanup == hybridscalingv2.CleanupDrain {
+		opts = append(opts, client.PropagationPolicy(metav1.DeletePropagationForeground))
+	}
+	gone, deleting, err := r.deleteRevision(ctx, ts, namespace, revision, opts...)
+	if err != nil {
+		return false, err
+	}
+	switch cleanup {
+	case hybridscalingv2.CleanupDrain:
+		if !gone {
+			setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionFalse, "Draining",
+				fmt.Sprintf("waiting for the %d pods of revision %s to terminate", len(running), revision))
+		}
+		return gone, nil
+	case hybridscalingv2.CleanupForceDelete:
+		if !gone && !deleting {
+			return false, nil
+		}
+	default:
+		return gone || deleting, nil
+	}
+
+	// Terminating pods can hold worker node resources for a long time, ForceDelete frees them right away
+	for i := range RevisionPodList.Items {
+		pod := &RevisionPodList.Items[i]
+		if err := r.Delete(ctx, pod, client.GracePeriodSeconds(0)); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+		loggerSD.Info("Force delete pod ", "POD_NAME", pod.Name)
+		r.event(ts, corev1.EventTypeNormal, "PodForceDeleted", "Force deleted pod %s of revision %s", pod.Name, revision)
+	}
+	return true, nil
+}
+
+// deleteRevision deletes the revision with the given options. It reports whether the revision is gone,
+// and whether its deletion was started by an earlier pass.
+func (r *TrafficStatReconciler) deleteRevision(ctx context.Context, ts *hybridscalingv2.TrafficStat, namespace, revision string, opts ...client.DeleteOption) (gone, deleting bool, err error) {
+	oldRevision := &servingv1.Revision{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: revision}, oldRevision); err != nil {
+		return apierrors.IsNotFound(err), false, client.IgnoreNotFound(err)
+	}
+	if !oldRevision.DeletionTimestamp.IsZero() {
+		return false, true, nil
+	}
+	loggerSD.Info("Ask to delete Revision", "REVISION_NAME", revision)
+	if err := r.Delete(ctx, oldRevision, opts...); err != nil {
+		return apierrors.IsNotFound(err), false, client.IgnoreNotFound(err)
+	}
+	loggerSD.Info("Delete Revision ", "REVISION_NAME", revision)
+	r.event(ts, corev1.EventTypeNormal, "RevisionDeleted", "Deleted revision %s", revision)
+	return false, false, nil
+}
+
+// blockingDisruptionBudget returns the name of a PodDisruptionBudget that does not allow the pods to be removed,
+// empty when all of them may go.
+func (r *TrafficStatReconciler) blockingDisruptionBudget(ctx context.Context, namespace string, pods []corev1.Pod) (string, error) {
+	budgets := &policyv1.PodDisruptionBudgetList{}
+	if err := r.List(ctx, budgets, client.InNamespace(namespace)); err != nil {
+		return "", err
+	}
+	for i := range budgets.Items {
+		if !disruptionAllowed(&budgets.Items[i], pods) {
+			return budgets.Items[i].Name, nil
+		}
+	}
+	return "", nil
+}
+
+// disruptionAllowed reports whether the PodDisruptionBudget allows all the pods it selects among the given ones
+// to be disrupted at once.
+func disruptionAllowed(budget *policyv1.PodDisruptionBudget, pods []corev1.Pod) bool {
+	selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+	if err != nil {
+		return true
+	}
+	var selected int32
+	for _, pod := range pods {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			selected++
+		}
+	}
+	return selected == 0 || budget.Status.DisruptionsAllowed >= selected
+}
+
+// event records a Kubernetes Event on the TrafficStat.
+func (r *TrafficStatReconciler) event(ts *hybridscalingv2.TrafficStat, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(ts, eventtype, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestDisruptionAllowed(t *testing.T) {
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "svc-00001-a", Labels: map[string]string{"app": "svc"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "svc-00001-b", Labels: map[string]string{"app": "svc"}}},
+	}
+	budget := func(selector map[string]string, allowed int32) *policyv1.PodDisruptionBudget {
+		return &policyv1.PodDisruptionBudget{
+			Spec:   policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: selector}},
+			Status: policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+		}
+	}
+	for _, tc := range []struct {
+		name   string
+		budget *policyv1.PodDisruptionBudget
+		want   bool
+	}{
+		{name: "enough disruptions allowed", budget: budget(map[string]string{"app": "svc"}, 2), want: true},
+		{name: "too few disruptions allowed", budget: budget(map[string]string{"app": "svc"}, 1), want: false},
+		{name: "other pods selected", budget: budget(map[string]string{"app": "other"}, 0), want: true},
+	} {
+		if got := disruptionAllowed(tc.budget, pods); got != tc.want {
+			t.Errorf("%s: disruptionAllowed = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCleanupRevision(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "service-a-00001-deployment-abc", Namespace: "tenant-a",
+		Labels: map[string]string{serving.RevisionLabelKey: "service-a-00001", "app": "service-a"}}}
+	budget := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "service-a"}}},
+	}
+	for _, tc := range []struct {
+		name    string
+		cleanup hybridscalingv2.CleanupPolicy
+		budget  bool
+		// want is what each of two passes reports
+		want       [2]bool
+		wantGone   bool
+		wantEvents int
+	}{
+		{name: "knative gc", cleanup: hybridscalingv2.CleanupKnativeGC, want: [2]bool{true, true}},
+		{name: "delete revision", cleanup: hybridscalingv2.CleanupDeleteRevision, want: [2]bool{false, true}, wantGone: true, wantEvents: 1},
+		{name: "drain", cleanup: hybridscalingv2.CleanupDrain, want: [2]bool{false, true}, wantGone: true, wantEvents: 1},
+		{name: "held by budget", cleanup: hybridscalingv2.CleanupDrain, budget: true, want: [2]bool{false, false}, wantEvents: 1},
+	} {
+		revision := &servingv1.Revision{ObjectMeta: metav1.ObjectMeta{Name: "service-a-00001", Namespace: "tenant-a"}}
+		objects := []client.Object{revision, pod.DeepCopy()}
+		if tc.budget {
+			objects = append(objects, budget.DeepCopy())
+		}
+		recorder := record.NewFakeRecorder(10)
+		r := &TrafficStatReconciler{Client: newTestClient(t, objects...), Recorder: recorder}
+		ts := &hybridscalingv2.TrafficStat{}
+
+		for pass, want := range tc.want {
+			cleaned, err := r.cleanupRevision(context.Background(), ts, "tenant-a", "service-a-00001", tc.cleanup)
+			if err != nil {
+				t.Fatalf("%s: cleanupRevision() error = %v", tc.name, err)
+			}
+			if cleaned != want {
+				t.Errorf("%s: pass %d reported cleaned %v, want %v", tc.name, pass+1, cleaned, want)
+			}
+		}
+		err := r.Get(context.Background(), client.ObjectKeyFromObject(revision), &servingv1.Revision{})
+		if gone := apierrors.IsNotFound(err); gone != tc.wantGone {
+			t.Errorf("%s: revision gone = %v, want %v", tc.name, gone, tc.wantGone)
+		}
+		if events := len(recorder.Events); events != tc.wantEvents {
+			t.Errorf("%s: %d Events recorded, want %d", tc.name, events, tc.wantEvents)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/serving/pkg/apis/autoscaling"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+const (
+	// defaultFallbackProfileName is the namespace default HybridScalingProfile of the NamespaceDefault fallback.
+	defaultFallbackProfileName = "default"
+	// minNotFoundBackoff and maxNotFoundBackoff bound the requeue interval while the profile or the service is missing.
+	minNotFoundBackoff = 10 * time.Second
+	maxNotFoundBackoff = 10 * time.Minute
+)
+
+// notFoundBackoff returns when to look again for a missing object, reported False in the condition.
+// The watches reconcile the TrafficStat as soon as the object is created, the requeue only covers missed events.
+func notFoundBackoff(ts *hybridscalingv2.TrafficStat, conditionType string, now time.Time) time.Duration {
+	return conditionBackoff(ts, conditionType, metav1.ConditionFalse, minNotFoundBackoff, maxNotFoundBackoff, now)
+}
+
+// conditionBackoff returns when to look again while the condition keeps the given status. The interval is
+// the time it has had that status, so it doubles with every attempt, between min and max.
+func conditionBackoff(ts *hybridscalingv2.TrafficStat, conditionType string, status metav1.ConditionStatus, min, max time.Duration, now time.Time) time.Duration {
+	condition := meta.FindStatusCondition(ts.Status.Conditions, conditionType)
+	if condition == nil || condition.Status != status {
+		return min
+	}
+	backoff := now.Sub(condition.LastTransitionTime.Time)
+	if backoff < min {
+		return min
+	}
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// fallbackPolicy returns the fallback policy of the TrafficStat, None when it has none.
+func fallbackPolicy(ts *hybridscalingv2.TrafficStat) hybridscalingv2.FallbackPolicy {
+	if ts.Spec.Fallback == nil {
+		return hybridscalingv2.FallbackPolicy{Mode: hybridscalingv2.FallbackNone}
+	}
+	policy := *ts.Spec.Fallback
+	if policy.Mode == "" {
+		policy.Mode = hybridscalingv2.FallbackNone
+	}
+	if policy.ProfileName == "" {
+		policy.ProfileName = defaultFallbackProfileName
+	}
+	return policy
+}
+
+// namespaceDefaultProfile returns the HybridScalingProfile the NamespaceDefault fallback uses in the namespace.
+func (r *TrafficStatReconciler) namespaceDefaultProfile(ctx context.Context, policy hybridscalingv2.FallbackPolicy, namespace string) (*hybridscalingv2.HybridScalingProfileSpec, string, error) {
+	profile := &hybridscalingv2.HybridScalingProfile{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: policy.ProfileName}, profile); err != nil {
+		return nil, "", err
+	}
+	if err := validateProfile(&profile.Spec); err != nil {
+		return nil, "", fmt.Errorf("HybridScalingProfile %s: %w", profile.Name, err)
+	}
+	return &profile.Spec, "namespace default HybridScalingProfile " + profile.Name, nil
+}
+
+// reconcileFallback handles a service without a hybrid profile with the None or MinScale fallback.
+// It looks for the profile again with notFoundBackoff.
+func (r *TrafficStatReconciler) reconcileFallback(ctx context.Context, ts *hybridscalingv2.TrafficStat, svc *servingv1.Service) (ctrl.Result, error) {
+	result := ctrl.Result{RequeueAfter: notFoundBackoff(ts, hybridscalingv2.ConditionProfileFound, time.Now())}
+	policy := fallbackPolicy(ts)
+	if policy.Mode != hybridscalingv2.FallbackMinScale {
+		setCondition(ts, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "NoProfile",
+			"the service has no hybrid profile, its Knative autoscaling settings are left as they are")
+		return result, nil
+	}
+	if policy.Concurrency == nil || *policy.Concurrency < 1 {
+		setCondition(ts, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "InvalidFallback",
+			"spec.fallback.concurrency is required by the MinScale fallback")
+		return result, nil
+	}
+
+	traffic := ts.Spec.Traffic.Value.AsApproximateFloat64()
+	if traffic < 0 {
+		setCondition(ts, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "InvalidTraffic",
+			fmt.Sprintf("spec.traffic.value %s must not be negative", ts.Spec.Traffic.Value.String()))
+		return result, nil
+	}
+	utilization, source, err := r.targetUtilization(ctx, svc, &hybridscalingv2.HybridScalingProfileSpec{}, ts.Spec.Traffic.Unit, false)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	pods := int32(math.Ceil(traffic / (float64(*policy.Concurrency) * utilization)))
+	setDecision(ts, hybridscalingv2.DecisionStatus{
+		Concurrency:  strconv.Itoa(int(*policy.Concurrency)),
+		ExpectedPods: pods,
+	})
+
+	// Only min-scale of the running revision moves, so no new revision is created
+	pa, err := r.readyPodAutoscaler(ctx, svc)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pa == nil {
+		setCondition(ts, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "NoReadyRevision",
+			"the MinScale fallback waits for a ready revision of the service")
+		return ctrl.Result{RequeueAfter: conditionBackoff(ts, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse,
+			revisionPollInterval, maxReadyRevisionBackoff, time.Now())}, nil
+	}
+	running := int32(0)
+	if pa.Status.ActualScale != nil {
+		running = *pa.Status.ActualScale
+	}
+	scale := r.validateScale(ctx, ts, svc, nil, pods, serviceMaxScale(nil, pa.Annotations), running)
+	ts.Status.Decision.Scale = scale
+	setCondition(ts, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionTrue, "Fallback",
+		fmt.Sprintf("no hybrid profile: min-scale %d from concurrency %d at %v%% target utilization (%s)",
+			scale.Applied, *policy.Concurrency, utilization*100, source))
+
+	if ts.Spec.Suspend {
+		return result, nil
+	}
+	minScale := strconv.Itoa(int(scale.Applied))
+	if pa.Annotations[autoscaling.MinScaleAnnotationKey] != minScale {
+		if err := r.recordOriginal(ctx, ts, svc, []string{autoscaling.MinScaleAnnotationKey}, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.patchPodAutoscaler(ctx, pa, map[string]string{autoscaling.MinScaleAnnotationKey: minScale}); err != nil {
+			return ctrl.Result{}, err
+		}
+		loggerSD.Info("No hybrid profile, scaled PodAutoscaler", "PA_NAME", pa.Name, "NUMBEROFPOD", minScale)
+	}
+	setAppliedRevision(ts, svc.Status.LatestReadyRevisionName)
+	return result, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/serving/pkg/apis/autoscaling"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestNotFoundBackoff(t *testing.T) {
+	now := time.Now()
+	missingFor := func(d time.Duration) *hybridscalingv2.TrafficStat {
+		return &hybridscalingv2.TrafficStat{Status: hybridscalingv2.TrafficStatStatus{Conditions: []metav1.Condition{{
+			Type: hybridscalingv2.ConditionServiceFound, Status: metav1.ConditionFalse, LastTransitionTime: metav1.NewTime(now.Add(-d)),
+		}}}}
+	}
+	for _, tc := range []struct {
+		name string
+		ts   *hybridscalingv2.TrafficStat
+		want time.Duration
+	}{
+		{name: "no condition", ts: &hybridscalingv2.TrafficStat{}, want: minNotFoundBackoff},
+		{name: "just missing", ts: missingFor(0), want: minNotFoundBackoff},
+		{name: "missing for a while", ts: missingFor(40 * time.Second), want: 40 * time.Second},
+		{name: "missing for long", ts: missingFor(time.Hour), want: maxNotFoundBackoff},
+	} {
+		if got := notFoundBackoff(tc.ts, hybridscalingv2.ConditionServiceFound, now); got != tc.want {
+			t.Errorf("%s: notFoundBackoff() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFallbackMinScale(t *testing.T) {
+	pa := &autoscalingv1alpha1.PodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "service-a-00001", Namespace: "tenant-a",
+		Annotations: map[string]string{autoscaling.MinScaleAnnotationKey: "1"}}}
+	svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"}}
+	svc.Status.LatestCreatedRevisionName = "service-a-00001"
+	svc.Status.LatestReadyRevisionName = "service-a-00001"
+	concurrency := int32(10)
+	ts := &hybridscalingv2.TrafficStat{ObjectMeta: metav1.ObjectMeta{Name: "traffic", Namespace: "tenant-a"}, Spec: hybridscalingv2.TrafficStatSpec{
+		TargetRef: hybridscalingv2.TargetReference{Name: "service-a"},
+		Traffic:   hybridscalingv2.PredictedTraffic{Value: resource.MustParse("100")},
+		Fallback:  &hybridscalingv2.FallbackPolicy{Mode: hybridscalingv2.FallbackMinScale, Concurrency: &concurrency},
+	}}
+	r := &TrafficStatReconciler{Client: newTestClient(t, pa, ts)}
+
+	if _, err := r.reconcileFallback(context.Background(), ts, svc); err != nil {
+		t.Fatalf("reconcileFallback() error = %v", err)
+	}
+	// ceil(100 / (10 * 0.7)) = 15 pods
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(pa), pa); err != nil {
+		t.Fatal(err)
+	}
+	if got := pa.Annotations[autoscaling.MinScaleAnnotationKey]; got != "15" {
+		t.Errorf("min-scale = %q, want 15", got)
+	}
+	// Only the min-scale the fallback writes is recorded, as it was on the PodAutoscaler
+	if original := ts.Status.Original; original == nil || len(original.Annotations) != 1 ||
+		original.Annotations[autoscaling.MinScaleAnnotationKey] != "1" || original.Service != "tenant-a/service-a" {
+		t.Errorf("original = %+v, want min-scale 1 of tenant-a/service-a", original)
+	}
+	if ts.Status.Decision == nil || ts.Status.Decision.AppliedRevision != "service-a-00001" {
+		t.Errorf("decision = %+v, want service-a-00001 applied", ts.Status.Decision)
+	}
+}
+
+func TestFallbackWaitsForReadyRevision(t *testing.T) {
+	r := &TrafficStatReconciler{Client: newTestClient(t)}
+	svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"}}
+	// The latest revision never becomes ready, for instance its image cannot be pulled
+	svc.Status.LatestCreatedRevisionName = "service-a-00002"
+	svc.Status.LatestReadyRevisionName = "service-a-00001"
+	concurrency := int32(10)
+
+	for _, tc := range []struct {
+		name    string
+		waiting time.Duration
+		want    time.Duration
+	}{
+		{name: "just waiting", want: revisionPollInterval},
+		{name: "waiting for a while", waiting: 20 * time.Second, want: 20 * time.Second},
+		{name: "waiting for long", waiting: time.Hour, want: maxReadyRevisionBackoff},
+	} {
+		ts := &hybridscalingv2.TrafficStat{Spec: hybridscalingv2.TrafficStatSpec{
+			TargetRef: hybridscalingv2.TargetReference{Name: "service-a"},
+			Traffic:   hybridscalingv2.PredictedTraffic{Value: resource.MustParse("100")},
+			Fallback:  &hybridscalingv2.FallbackPolicy{Mode: hybridscalingv2.FallbackMinScale, Concurrency: &concurrency},
+		}}
+		if tc.waiting > 0 {
+			ts.Status.Conditions = []metav1.Condition{{Type: hybridscalingv2.ConditionDecisionComputed, Status: metav1.ConditionFalse,
+				Reason: "NoReadyRevision", LastTransitionTime: metav1.NewTime(time.Now().Add(-tc.waiting))}}
+		}
+		result, err := r.reconcileFallback(context.Background(), ts, svc)
+		if err != nil {
+			t.Fatalf("%s: reconcileFallback() error = %v", tc.name, err)
+		}
+		// The age of the condition is measured a moment after it was set up
+		if result.RequeueAfter < tc.want || result.RequeueAfter > tc.want+time.Second {
+			t.Errorf("%s: requeue after %v, want %v", tc.name, result.RequeueAfter, tc.want)
+		}
+	}
+}
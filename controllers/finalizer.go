@@ -0,0 +1,219 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+// TrafficStatFinalizer keeps a deleted TrafficStat until the settings of its Knative Service are given back.
+const TrafficStatFinalizer = "hybridscaling.knativescaling.dcn.ssu.ac.kr/restore-service"
+
+// recordOriginal adds the template annotations and container resources of the service the controller is about to write
+// to status.original, unless they are recorded already, and saves the status before the service is touched.
+// Annotation values are taken from the PodAutoscaler of the running revision when there is one.
+func (r *TrafficStatReconciler) recordOriginal(ctx context.Context, ts *hybridscalingv2.TrafficStat, svc *servingv1.Service, keys []string, resources []corev1.ResourceName) error {
+	original := ts.Status.Original
+	if original == nil {
+		original = &hybridscalingv2.TemplateSnapshot{Service: indexKey(svc.Namespace, svc.Name)}
+	}
+	added := &hybridscalingv2.TemplateSnapshot{Annotations: map[string]string{}}
+	for _, k := range keys {
+		if _, ok := original.Annotations[k]; !ok {
+			added.Annotations[k] = svc.Spec.Template.Annotations[k]
+		}
+	}
+	added, err := r.podAutoscalerSnapshot(ctx, svc, added)
+	if err != nil {
+		return err
+	}
+	// Snapshots recorded with the whole resources of the container hold every resource already
+	wholeResources := len(original.ResourceNames) == 0 && (len(original.Resources.Requests) > 0 || len(original.Resources.Limits) > 0)
+	container := servingContainer(&svc.Spec.Template.Spec)
+	for _, name := range resources {
+		if wholeResources || container == nil || containsResourceName(original.ResourceNames, name) {
+			continue
+		}
+		added.ResourceNames = append(added.ResourceNames, name)
+		recordQuantity(&original.Resources.Requests, container.Resources.Requests, name)
+		recordQuantity(&original.Resources.Limits, container.Resources.Limits, name)
+	}
+	if len(added.Annotations) == 0 && len(added.ResourceNames) == 0 {
+		return nil
+	}
+
+	if original.Annotations == nil {
+		original.Annotations = map[string]string{}
+	}
+	for k, v := range added.Annotations {
+		original.Annotations[k] = v
+	}
+	original.ResourceNames = append(original.ResourceNames, added.ResourceNames...)
+	ts.Status.Original = original
+	return r.Status().Update(ctx, ts)
+}
+
+// containsResourceName reports whether the resource is in names.
+func containsResourceName(names []corev1.ResourceName, name corev1.ResourceName) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// recordQuantity copies the quantity of the resource from the container's list to the recorded one, if it is set.
+func recordQuantity(recorded *corev1.ResourceList, list corev1.ResourceList, name corev1.ResourceName) {
+	quantity, ok := list[name]
+	if !ok {
+		return
+	}
+	if *recorded == nil {
+		*recorded = corev1.ResourceList{}
+	}
+	(*recorded)[name] = quantity
+}
+
+// targetKey returns the namespace and name of the Knative Service of the TrafficStat.
+func targetKey(ts *hybridscalingv2.TrafficStat) client.ObjectKey {
+	return client.ObjectKey{Namespace: targetNamespace(ts), Name: targetServiceName(ts)}
+}
+
+// originalOf reports whether the original settings were taken from the service.
+// Snapshots recorded without their service are taken to be of the target.
+func originalOf(snapshot *hybridscalingv2.TemplateSnapshot, service client.ObjectKey) bool {
+	return snapshot.Service == "" || snapshot.Service == indexKey(service.Namespace, service.Name)
+}
+
+// resetForTarget gives the previous service back as the deletion policy says once spec.targetRef moved to another one,
+// then drops the original settings and the rollout so the settings of the new target are taken.
+// It returns the previous service, empty when the target is the same. Snapshots recorded without their service are given the target's.
+func (r *TrafficStatReconciler) resetForTarget(ctx context.Context, ts *hybridscalingv2.TrafficStat) (string, error) {
+	original := ts.Status.Original
+	switch {
+	case original == nil:
+		return "", nil
+	case original.Service == "":
+		original.Service = indexKey(targetNamespace(ts), targetServiceName(ts))
+		return "", nil
+	case originalOf(original, targetKey(ts)):
+		return "", nil
+	}
+	namespace, name, _ := strings.Cut(original.Service, "/")
+	if err := r.releaseService(ctx, ts, client.ObjectKey{Namespace: namespace, Name: name}); err != nil {
+		return "", err
+	}
+	ts.Status.Original = nil
+	// The rollout and its revisions belong to the previous service
+	ts.Status.Rollout = nil
+	return original.Service, nil
+}
+
+// deletionPolicy returns the deletion policy of the TrafficStat, Restore when it has none.
+func deletionPolicy(ts *hybridscalingv2.TrafficStat) hybridscalingv2.DeletionPolicy {
+	if ts.Spec.OnDelete == nil {
+		return hybridscalingv2.DeletionPolicy{Mode: hybridscalingv2.DeletionRestore}
+	}
+	policy := *ts.Spec.OnDelete
+	if policy.Mode == "" {
+		policy.Mode = hybridscalingv2.DeletionRestore
+	}
+	return policy
+}
+
+// reconcileDelete gives the service back as the deletion policy says and removes the finalizer.
+// A failed restore is retried, the TrafficStat stays until it succeeds.
+func (r *TrafficStatReconciler) reconcileDelete(ctx context.Context, ts *hybridscalingv2.TrafficStat) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(ts, TrafficStatFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if err := r.releaseService(ctx, ts, targetKey(ts)); err != nil {
+		loggerSD.Error(err, "unable to restore the Knative Service of the deleted TrafficStat", "TRAFFICSTAT", ts.Name)
+		return ctrl.Result{}, err
+	}
+	controllerutil.RemoveFinalizer(ts, TrafficStatFinalizer)
+	return ctrl.Result{}, r.Update(ctx, ts)
+}
+
+// releaseService puts the original settings, or the baseline, back on the service and on the PodAutoscaler of its
+// running revision. A Gradual rollout in progress has its traffic routed back to the latest revision.
+func (r *TrafficStatReconciler) releaseService(ctx context.Context, ts *hybridscalingv2.TrafficStat, service client.ObjectKey) error {
+	policy := deletionPolicy(ts)
+	snapshot := ts.Status.Original
+	switch policy.Mode {
+	case hybridscalingv2.DeletionKeep:
+		return nil
+	case hybridscalingv2.DeletionBaseline:
+		snapshot = policy.Baseline
+	}
+	if snapshot == nil {
+		return nil
+	}
+	if !r.watchesNamespace(service.Namespace) {
+		loggerSD.Info("Target namespace is not watched, service not restored", "TARGET_NAMESPACE", service.Namespace)
+		return nil
+	}
+	if policy.Mode == hybridscalingv2.DeletionRestore && !originalOf(snapshot, service) {
+		loggerSD.Info("Original settings belong to another service, not restored", "SERVICE_NAME", service.Name, "ORIGINAL_SERVICE", snapshot.Service)
+		r.event(ts, corev1.EventTypeWarning, "RestoreSkipped", "the original settings were taken from Knative Service %s, not from %s", snapshot.Service, service.Name)
+		return nil
+	}
+
+	svc := &servingv1.Service{}
+	if err := r.Get(ctx, service, svc); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	snapshot = snapshot.DeepCopy()
+	if policy.Mode == hybridscalingv2.DeletionBaseline && len(snapshot.Resources.Requests) == 0 && len(snapshot.Resources.Limits) == 0 {
+		if container := servingContainer(&svc.Spec.Template.Spec); container != nil {
+			snapshot.Resources = *container.Resources.DeepCopy()
+		}
+	}
+	var traffic []servingv1.TrafficTarget
+	if rollout := ts.Status.Rollout; rollout != nil && rollout.Strategy == hybridscalingv2.RolloutStrategyGradual &&
+		rollout.Phase != hybridscalingv2.RolloutPhaseDone && rollout.Phase != hybridscalingv2.RolloutPhaseFailed {
+		traffic = splitTraffic("", "", 100)
+	}
+
+	// The PodAutoscaler is read first, restoring the template may replace the running revision
+	pa, err := r.readyPodAutoscaler(ctx, svc)
+	if err != nil {
+		return err
+	}
+	if err := r.restoreTemplate(ctx, svc, snapshot, traffic, revisionNaming(ts.Spec.RolloutPolicy)); err != nil {
+		return err
+	}
+	if pa != nil {
+		if err := r.restorePodAutoscaler(ctx, pa, snapshot.Annotations); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+	}
+	loggerSD.Info("Restored Knative Service", "SERVICE_NAME", svc.Name, "MODE", policy.Mode)
+	r.event(ts, corev1.EventTypeNormal, "ServiceRestored", "%s settings put back on Knative Service %s", policy.Mode, svc.Name)
+	return nil
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/serving/pkg/apis/autoscaling"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestReleaseService(t *testing.T) {
+	cpu := func(level string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(level)},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(level)},
+		}
+	}
+	newService := func() *servingv1.Service {
+		svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"}}
+		svc.Spec.Template.Annotations = map[string]string{autoscaling.TargetAnnotationKey: "10", autoscaling.MinScaleAnnotationKey: "1"}
+		svc.Spec.Template.Spec.Containers = []corev1.Container{{Resources: cpu("500m")}}
+		return svc
+	}
+	// The controller recorded the settings it wrote: the autoscaling annotations and cpu
+	original := templateSnapshot(newService(), hybridscalingv2.HybridPair{})
+	original.ResourceNames = []corev1.ResourceName{corev1.ResourceCPU}
+	original.Service = "tenant-a/service-a"
+
+	for _, tc := range []struct {
+		name          string
+		onDelete      *hybridscalingv2.DeletionPolicy
+		originalOf    string
+		wantMinScale  string
+		wantTarget    string
+		wantResources string
+	}{
+		{name: "restore", wantMinScale: "1", wantTarget: "10", wantResources: "500m"},
+		{name: "baseline", onDelete: &hybridscalingv2.DeletionPolicy{Mode: hybridscalingv2.DeletionBaseline, Baseline: &hybridscalingv2.TemplateSnapshot{
+			Annotations: map[string]string{autoscaling.MinScaleAnnotationKey: "", autoscaling.TargetAnnotationKey: "50"},
+		}}, wantTarget: "50", wantResources: "1500m"},
+		{name: "keep", onDelete: &hybridscalingv2.DeletionPolicy{Mode: hybridscalingv2.DeletionKeep}, wantMinScale: "15", wantTarget: "20", wantResources: "1500m"},
+		// The original settings of another service are not put on the target
+		{name: "other service", originalOf: "tenant-a/service-b", wantMinScale: "15", wantTarget: "20", wantResources: "1500m"},
+	} {
+		// The hybrid pair the controller applied
+		svc := newService()
+		svc.Spec.Template.Annotations = map[string]string{autoscaling.TargetAnnotationKey: "20", autoscaling.MinScaleAnnotationKey: "15",
+			autoscaling.InitialScaleAnnotationKey: "15"}
+		svc.Spec.Template.Spec.Containers[0].Resources = cpu("1500m")
+		// The memory request the owner set since is not the controller's
+		svc.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceMemory] = resource.MustParse("256Mi")
+		r := &TrafficStatReconciler{Client: newTestClient(t, svc)}
+		snapshot := original.DeepCopy()
+		if tc.originalOf != "" {
+			snapshot.Service = tc.originalOf
+		}
+		ts := &hybridscalingv2.TrafficStat{
+			Spec:   hybridscalingv2.TrafficStatSpec{TargetRef: hybridscalingv2.TargetReference{Name: "service-a", Namespace: "tenant-a"}, OnDelete: tc.onDelete},
+			Status: hybridscalingv2.TrafficStatStatus{Original: snapshot},
+		}
+
+		if err := r.releaseService(context.Background(), ts, targetKey(ts)); err != nil {
+			t.Fatalf("%s: releaseService() error = %v", tc.name, err)
+		}
+		if err := r.Get(context.Background(), client.ObjectKeyFromObject(svc), svc); err != nil {
+			t.Fatal(err)
+		}
+		annotations := svc.Spec.Template.Annotations
+		if annotations[autoscaling.MinScaleAnnotationKey] != tc.wantMinScale || annotations[autoscaling.TargetAnnotationKey] != tc.wantTarget {
+			t.Errorf("%s: annotations = %v, want min-scale %q, target %q", tc.name, annotations, tc.wantMinScale, tc.wantTarget)
+		}
+		if _, ok := annotations[autoscaling.InitialScaleAnnotationKey]; ok && tc.name == "restore" {
+			t.Errorf("%s: initial-scale was not removed", tc.name)
+		}
+		if limit := svc.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU]; limit.Cmp(resource.MustParse(tc.wantResources)) != 0 {
+			t.Errorf("%s: cpu limit = %s, want %s", tc.name, limit.String(), tc.wantResources)
+		}
+		if memory := svc.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceMemory]; memory.Cmp(resource.MustParse("256Mi")) != 0 {
+			t.Errorf("%s: memory request = %s, want 256Mi kept", tc.name, memory.String())
+		}
+	}
+}
+
+func TestRecordOriginal(t *testing.T) {
+	cpu := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}
+	target := "tenant-a/service-a"
+
+	for _, tc := range []struct {
+		name      string
+		original  *hybridscalingv2.TemplateSnapshot
+		keys      []string
+		resources []corev1.ResourceName
+		want      *hybridscalingv2.TemplateSnapshot
+	}{
+		{name: "first write", keys: []string{autoscaling.TargetAnnotationKey, autoscaling.InitialScaleAnnotationKey}, resources: []corev1.ResourceName{corev1.ResourceCPU},
+			want: &hybridscalingv2.TemplateSnapshot{
+				Annotations:   map[string]string{autoscaling.TargetAnnotationKey: "10", autoscaling.InitialScaleAnnotationKey: ""},
+				Resources:     corev1.ResourceRequirements{Requests: cpu, Limits: cpu},
+				ResourceNames: []corev1.ResourceName{corev1.ResourceCPU},
+				Service:       target,
+			}},
+		// The controller's own values are on the service by now, what was recorded before is kept
+		{name: "recorded before", keys: []string{autoscaling.TargetAnnotationKey, autoscaling.MinScaleAnnotationKey},
+			original: &hybridscalingv2.TemplateSnapshot{Annotations: map[string]string{autoscaling.MinScaleAnnotationKey: "1"}, Service: target},
+			want: &hybridscalingv2.TemplateSnapshot{
+				Annotations: map[string]string{autoscaling.TargetAnnotationKey: "10", autoscaling.MinScaleAnnotationKey: "1"},
+				Service:     target,
+			}},
+		{name: "whole resources recorded", resources: []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory},
+			original: &hybridscalingv2.TemplateSnapshot{Resources: corev1.ResourceRequirements{Limits: cpu}, Service: target},
+			want:     &hybridscalingv2.TemplateSnapshot{Resources: corev1.ResourceRequirements{Limits: cpu}, Service: target}},
+	} {
+		svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"}}
+		svc.Spec.Template.Annotations = map[string]string{autoscaling.TargetAnnotationKey: "10", autoscaling.MinScaleAnnotationKey: "15",
+			autoscaling.MaxScaleAnnotationKey: "20"}
+		// The memory request is not written by the controller, it is not recorded
+		svc.Spec.Template.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("256Mi")},
+			Limits:   cpu,
+		}}}
+		ts := &hybridscalingv2.TrafficStat{ObjectMeta: metav1.ObjectMeta{Name: "traffic", Namespace: "tenant-a"},
+			Status: hybridscalingv2.TrafficStatStatus{Original: tc.original}}
+		r := &TrafficStatReconciler{Client: newTestClient(t, svc, ts)}
+
+		if err := r.recordOriginal(context.Background(), ts, svc, tc.keys, tc.resources); err != nil {
+			t.Fatalf("%s: recordOriginal() error = %v", tc.name, err)
+		}
+		if !equality.Semantic.DeepEqual(ts.Status.Original, tc.want) {
+			t.Errorf("%s: original = %+v, want %+v", tc.name, ts.Status.Original, tc.want)
+		}
+		saved := &hybridscalingv2.TrafficStat{}
+		if err := r.Get(context.Background(), client.ObjectKeyFromObject(ts), saved); err != nil {
+			t.Fatal(err)
+		}
+		if !equality.Semantic.DeepEqual(saved.Status.Original, tc.want) {
+			t.Errorf("%s: saved original = %+v, want %+v", tc.name, saved.Status.Original, tc.want)
+		}
+	}
+}
+
+func TestResetForTarget(t *testing.T) {
+	svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"}}
+	svc.Spec.Template.Annotations = map[string]string{autoscaling.MinScaleAnnotationKey: "1"}
+	original := &hybridscalingv2.TemplateSnapshot{Annotations: map[string]string{autoscaling.MinScaleAnnotationKey: "1"}}
+	gradual := &hybridscalingv2.RolloutStatus{Phase: hybridscalingv2.RolloutPhaseShifting, Strategy: hybridscalingv2.RolloutStrategyGradual,
+		PreviousRevision: "service-a-00001", NewRevision: "service-a-00002", TrafficPercent: 40}
+
+	for _, tc := range []struct {
+		name         string
+		target       hybridscalingv2.TargetReference
+		service      string
+		wantPrevious string
+		wantService  string
+	}{
+		{name: "same target", target: hybridscalingv2.TargetReference{Name: "service-a"}, service: "tenant-a/service-a", wantService: "tenant-a/service-a"},
+		{name: "recorded without service", target: hybridscalingv2.TargetReference{Name: "service-a"}, wantService: "tenant-a/service-a"},
+		{name: "moved", target: hybridscalingv2.TargetReference{Name: "service-b"}, service: "tenant-a/service-a", wantPrevious: "tenant-a/service-a"},
+		{name: "moved namespace", target: hybridscalingv2.TargetReference{Name: "service-a", Namespace: "tenant-b"}, service: "tenant-a/service-a",
+			wantPrevious: "tenant-a/service-a"},
+	} {
+		// service-a runs the hybrid pair, 40% of its traffic on the new revision of a Gradual rollout
+		live := svc.DeepCopy()
+		live.Spec.Template.Annotations = map[string]string{autoscaling.MinScaleAnnotationKey: "15"}
+		live.Spec.Traffic = splitTraffic("service-a-00001", "service-a-00002", 40)
+		r := &TrafficStatReconciler{Client: newTestClient(t, live)}
+		snapshot := original.DeepCopy()
+		snapshot.Service = tc.service
+		ts := &hybridscalingv2.TrafficStat{
+			ObjectMeta: metav1.ObjectMeta{Name: "traffic", Namespace: "tenant-a"},
+			Spec:       hybridscalingv2.TrafficStatSpec{TargetRef: tc.target},
+			Status:     hybridscalingv2.TrafficStatStatus{Original: snapshot, Rollout: gradual.DeepCopy()},
+		}
+
+		previous, err := r.resetForTarget(context.Background(), ts)
+		if err != nil {
+			t.Fatalf("%s: resetForTarget() error = %v", tc.name, err)
+		}
+		if previous != tc.wantPrevious {
+			t.Errorf("%s: resetForTarget() = %q, want %q", tc.name, previous, tc.wantPrevious)
+		}
+		if err := r.Get(context.Background(), client.ObjectKeyFromObject(live), live); err != nil {
+			t.Fatal(err)
+		}
+		if tc.wantPrevious == "" {
+			if ts.Status.Original == nil || ts.Status.Original.Service != tc.wantService || ts.Status.Rollout == nil {
+				t.Errorf("%s: original = %+v, want it kept with service %q", tc.name, ts.Status.Original, tc.wantService)
+			}
+			if got := live.Spec.Template.Annotations[autoscaling.MinScaleAnnotationKey]; got != "15" {
+				t.Errorf("%s: min-scale of the target = %q, want 15", tc.name, got)
+			}
+			continue
+		}
+		if ts.Status.Original != nil || ts.Status.Rollout != nil {
+			t.Errorf("%s: original %+v and rollout %+v of the previous target were kept", tc.name, ts.Status.Original, ts.Status.Rollout)
+		}
+		// The previous service is given back: its settings restored, all traffic on its latest revision
+		if got := live.Spec.Template.Annotations[autoscaling.MinScaleAnnotationKey]; got != "1" {
+			t.Errorf("%s: min-scale of the previous service = %q, want 1", tc.name, got)
+		}
+		if !equality.Semantic.DeepEqual(live.Spec.Traffic, splitTraffic("", "", 100)) {
+			t.Errorf("%s: traffic of the previous service = %+v, want all on the latest revision", tc.name, live.Spec.Traffic)
+		}
+	}
+}
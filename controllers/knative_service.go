@@ -0,0 +1,276 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/pkg/ptr"
+	"knative.dev/serving/pkg/apis/autoscaling"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+// FieldManager is the field manager recorded for every change the controller makes to a Knative Service.
+const FieldManager = "knative-hybrid-scaling"
+
+// servingContainer returns the container that serves traffic in a revision template.
+// Knative allows a single container to expose a port when several are defined; fall back to the first one.
+func servingContainer(spec *servingv1.RevisionSpec) *corev1.Container {
+	if len(spec.Containers) == 0 {
+		return nil
+	}
+	for i := range spec.Containers {
+		if len(spec.Containers[i].Ports) > 0 {
+			return &spec.Containers[i]
+		}
+	}
+	return &spec.Containers[0]
+}
+
+// setTemplateAnnotations sets the given autoscaling annotations on the revision template,
+// leaving every other annotation untouched.
+func setTemplateAnnotations(svc *servingv1.Service, annotations map[string]string) {
+	if svc.Spec.Template.Annotations == nil {
+		svc.Spec.Template.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		svc.Spec.Template.Annotations[k] = v
+	}
+}
+
+// setContainerResources sets request and limit of each given resource on the container,
+// leaving resources that are not part of the hybrid pair untouched.
+func setContainerResources(container *corev1.Container, resources corev1.ResourceList) {
+	if container.Resources.Requests == nil {
+		container.Resources.Requests = corev1.ResourceList{}
+	}
+	if container.Resources.Limits == nil {
+		container.Resources.Limits = corev1.ResourceList{}
+	}
+	for name, quantity := range resources {
+		container.Resources.Requests[name] = quantity
+		container.Resources.Limits[name] = quantity
+	}
+}
+
+// metricMatches reports whether the autoscaling.knative.dev/metric annotation value scales on the traffic unit.
+func metricMatches(metric string, unit hybridscalingv2.TrafficUnit) bool {
+	if unit == hybridscalingv2.TrafficUnitRPS {
+		return metric == autoscaling.RPS
+	}
+	return metric == "" || metric == autoscaling.Concurrency
+}
+
+// revisionNaming returns how revisions of a Service that sets its own revision name are named, Generate unless the policy says otherwise.
+func revisionNaming(policy *hybridscalingv2.RolloutPolicy) hybridscalingv2.RevisionNaming {
+	if policy == nil || policy.RevisionNaming == "" {
+		return hybridscalingv2.RevisionNamingGenerate
+	}
+	return policy.RevisionNaming
+}
+
+// nameRevision renames the revision template when the Service sets its own revision name and the template changed,
+// Knative rejects a template change that keeps the name. The generated name carries the generation the change gets,
+// so it is never used twice.
+func nameRevision(original, svc *servingv1.Service, naming hybridscalingv2.RevisionNaming) {
+	if original.Spec.Template.Name == "" || equality.Semantic.DeepEqual(original.Spec.Template, svc.Spec.Template) {
+		return
+	}
+	if naming == hybridscalingv2.RevisionNamingClear {
+		svc.Spec.Template.Name = ""
+		return
+	}
+	svc.Spec.Template.Name = fmt.Sprintf("%s-hybrid-%05d", svc.Name, svc.Generation+1)
+}
+
+// patchHybridPair applies the chosen concurrency target, pod count and container resources
+// to the live Knative Service with a merge patch. Image, env, probes, volumes and all other
+// fields set by the Service owner are left as they are. The patch carries the Service's
+// resourceVersion, so a concurrent edit makes it fail with a conflict instead of being overwritten.
+// Non-nil traffic targets replace the Service's traffic in the same patch.
+func (r *TrafficStatReconciler) patchHybridPair(ctx context.Context, svc *servingv1.Service, pair hybridscalingv2.HybridPair, traffic []servingv1.TrafficTarget, naming hybridscalingv2.RevisionNaming) error {
+	original := svc.DeepCopy()
+
+	annotations := map[string]string{
+		autoscaling.TargetAnnotationKey:       pair.Concurrency,
+		autoscaling.InitialScaleAnnotationKey: strconv.Itoa(int(pair.NumberOfPods)),
+		autoscaling.MinScaleAnnotationKey:     strconv.Itoa(int(pair.NumberOfPods)),
+	}
+	for k, v := range pair.Annotations {
+		annotations[k] = v
+	}
+	// The profile target is read in the unit of the prediction, KPA has to scale on the same metric
+	if current := svc.Spec.Template.Annotations[autoscaling.MetricAnnotationKey]; !metricMatches(current, pair.Unit) {
+		if pair.Unit == hybridscalingv2.TrafficUnitRPS {
+			annotations[autoscaling.MetricAnnotationKey] = autoscaling.RPS
+		} else {
+			annotations[autoscaling.MetricAnnotationKey] = autoscaling.Concurrency
+		}
+	}
+	setTemplateAnnotations(svc, annotations)
+	if container := servingContainer(&svc.Spec.Template.Spec); container != nil {
+		setContainerResources(container, pair.Resources)
+	}
+	nameRevision(original, svc, naming)
+	if traffic != nil {
+		svc.Spec.Traffic = traffic
+	}
+
+	patch := client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	return r.Patch(ctx, svc, patch, client.FieldOwner(FieldManager))
+}
+
+// concurrencyMatches reports whether the autoscaling.knative.dev/target annotation value equals the concurrency.
+// Knative accepts fractional targets, so "10" and "10.0" are the same target.
+func concurrencyMatches(target string, concurrency int32) bool {
+	value, err := strconv.ParseFloat(target, 64)
+	return err == nil && value == float64(concurrency)
+}
+
+// hasDefaultTraffic reports whether the Service routes all traffic to its latest revision,
+// which the Gradual rollout strategy relies on to restore the routing once it is done.
+func hasDefaultTraffic(svc *servingv1.Service) bool {
+	switch len(svc.Spec.Traffic) {
+	case 0:
+		return true
+	case 1:
+		target := svc.Spec.Traffic[0]
+		return target.LatestRevision != nil && *target.LatestRevision && target.Tag == "" &&
+			(target.Percent == nil || *target.Percent == 100)
+	}
+	return false
+}
+
+// splitTraffic returns the traffic targets routing percent of the requests to the new revision and the rest
+// to the previous one. At 100 percent all traffic goes back to the latest revision.
+func splitTraffic(previous, next string, percent int32) []servingv1.TrafficTarget {
+	if percent >= 100 {
+		return []servingv1.TrafficTarget{{LatestRevision: ptr.Bool(true), Percent: ptr.Int64(100)}}
+	}
+	targets := []servingv1.TrafficTarget{{RevisionName: previous, LatestRevision: ptr.Bool(false), Percent: ptr.Int64(int64(100 - percent))}}
+	if next == "" {
+		// The new revision is not known yet, keep the latest revision in the route without traffic
+		return append(targets, servingv1.TrafficTarget{LatestRevision: ptr.Bool(true), Percent: ptr.Int64(int64(percent))})
+	}
+	return append(targets, servingv1.TrafficTarget{RevisionName: next, LatestRevision: ptr.Bool(false), Percent: ptr.Int64(int64(percent))})
+}
+
+// patchTraffic replaces the traffic targets of the live Knative Service.
+func (r *TrafficStatReconciler) patchTraffic(ctx context.Context, svc *servingv1.Service, traffic []servingv1.TrafficTarget) error {
+	original := svc.DeepCopy()
+	svc.Spec.Traffic = traffic
+	patch := client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	return r.Patch(ctx, svc, patch, client.FieldOwner(FieldManager))
+}
+
+// templateSnapshot records the template annotations and container resources patchHybridPair changes for the pair,
+// so a failed rollout can restore them.
+func templateSnapshot(svc *servingv1.Service, pair hybridscalingv2.HybridPair) *hybridscalingv2.TemplateSnapshot {
+	snapshot := &hybridscalingv2.TemplateSnapshot{Annotations: map[string]string{}}
+	for _, k := range pairAnnotationKeys(pair) {
+		snapshot.Annotations[k] = svc.Spec.Template.Annotations[k]
+	}
+	if container := servingContainer(&svc.Spec.Template.Spec); container != nil {
+		snapshot.Resources = *container.Resources.DeepCopy()
+	}
+	return snapshot
+}
+
+// pairAnnotationKeys returns the template annotations patchHybridPair may write for the pair.
+func pairAnnotationKeys(pair hybridscalingv2.HybridPair) []string {
+	keys := []string{
+		autoscaling.TargetAnnotationKey,
+		autoscaling.InitialScaleAnnotationKey,
+		autoscaling.MinScaleAnnotationKey,
+		autoscaling.MetricAnnotationKey,
+	}
+	return append(keys, sortedKeys(pair.Annotations)...)
+}
+
+// sortedKeys returns the keys of the annotations in order.
+func sortedKeys(annotations map[string]string) []string {
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resourceNames returns the resources of the list in order.
+func resourceNames(resources corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// restoreTemplate puts the snapshot annotations and container resources back on the live Knative Service.
+// Annotations recorded empty are removed. Only the resources named in the snapshot are restored when it names any,
+// else its resources replace those of the container. Non-nil traffic targets replace the Service's traffic in the same patch.
+func (r *TrafficStatReconciler) restoreTemplate(ctx context.Context, svc *servingv1.Service, snapshot *hybridscalingv2.TemplateSnapshot, traffic []servingv1.TrafficTarget, naming hybridscalingv2.RevisionNaming) error {
+	original := svc.DeepCopy()
+
+	for k, v := range snapshot.Annotations {
+		if v == "" {
+			delete(svc.Spec.Template.Annotations, k)
+		} else {
+			setTemplateAnnotations(svc, map[string]string{k: v})
+		}
+	}
+	if container := servingContainer(&svc.Spec.Template.Spec); container != nil {
+		if len(snapshot.ResourceNames) == 0 {
+			container.Resources = *snapshot.Resources.DeepCopy()
+		}
+		for _, name := range snapshot.ResourceNames {
+			container.Resources.Requests = restoreQuantity(container.Resources.Requests, snapshot.Resources.Requests, name)
+			container.Resources.Limits = restoreQuantity(container.Resources.Limits, snapshot.Resources.Limits, name)
+		}
+	}
+	nameRevision(original, svc, naming)
+	if traffic != nil {
+		svc.Spec.Traffic = traffic
+	}
+
+	patch := client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	return r.Patch(ctx, svc, patch, client.FieldOwner(FieldManager))
+}
+
+// restoreQuantity puts the recorded quantity of the resource back in the list, or removes it when none was recorded.
+func restoreQuantity(list, recorded corev1.ResourceList, name corev1.ResourceName) corev1.ResourceList {
+	quantity, ok := recorded[name]
+	if !ok {
+		delete(list, name)
+		return list
+	}
+	if list == nil {
+		list = corev1.ResourceList{}
+	}
+	list[name] = quantity
+	return list
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/serving/pkg/apis/autoscaling"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestPatchHybridPairKeepsOwnerFields(t *testing.T) {
+	svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name: "service-a", Namespace: "tenant-a",
+		Labels:      map[string]string{"team": "a"},
+		Annotations: map[string]string{"owner.example.com/contact": "team-a"},
+	}}
+	svc.Spec.Template.Annotations = map[string]string{
+		autoscaling.TargetAnnotationKey:   "10",
+		autoscaling.MinScaleAnnotationKey: "1",
+		"owner.example.com/build":         "42",
+	}
+	svc.Spec.Template.Labels = map[string]string{"version": "v7"}
+	svc.Spec.Template.Spec.Containers = []corev1.Container{{
+		Name:  "app",
+		Image: "registry.example.com/app@sha256:0123",
+		Ports: []corev1.ContainerPort{{ContainerPort: 8080}},
+		Env:   []corev1.EnvVar{{Name: "MODE", Value: "production"}},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceEphemeralStorage: resource.MustParse("1Gi")},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+		},
+		ReadinessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/ready", Port: intstr.FromInt(8080)}}},
+		VolumeMounts:   []corev1.VolumeMount{{Name: "config", MountPath: "/etc/app"}},
+	}, {
+		Name:  "sidecar",
+		Image: "registry.example.com/sidecar:v1",
+	}}
+	svc.Spec.Template.Spec.Volumes = []corev1.Volume{{Name: "config", VolumeSource: corev1.VolumeSource{
+		ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}},
+	}}}
+	r := &TrafficStatReconciler{Client: newTestClient(t, svc)}
+
+	live := &servingv1.Service{}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(svc), live); err != nil {
+		t.Fatal(err)
+	}
+	// Only the hybrid annotations and the pair's resources of the serving container are expected to change
+	want := live.DeepCopy()
+	want.Spec.Template.Annotations[autoscaling.TargetAnnotationKey] = "20"
+	want.Spec.Template.Annotations[autoscaling.MinScaleAnnotationKey] = "12"
+	want.Spec.Template.Annotations[autoscaling.InitialScaleAnnotationKey] = "12"
+	want.Spec.Template.Annotations[autoscaling.MaxScaleAnnotationKey] = "15"
+	want.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU] = resource.MustParse("1500m")
+	want.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU] = resource.MustParse("1500m")
+
+	if err := r.patchHybridPair(context.Background(), live, hybridscalingv2.HybridPair{
+		Resources:    corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")},
+		Concurrency:  "20",
+		NumberOfPods: 12,
+		Annotations:  map[string]string{autoscaling.MaxScaleAnnotationKey: "15"},
+	}, nil, hybridscalingv2.RevisionNamingGenerate); err != nil {
+		t.Fatalf("patchHybridPair() error = %v", err)
+	}
+
+	patched := &servingv1.Service{}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(svc), patched); err != nil {
+		t.Fatal(err)
+	}
+	if !equality.Semantic.DeepEqual(patched.ObjectMeta.Labels, want.ObjectMeta.Labels) ||
+		!equality.Semantic.DeepEqual(patched.ObjectMeta.Annotations, want.ObjectMeta.Annotations) {
+		t.Errorf("service metadata = %+v, want %+v", patched.ObjectMeta, want.ObjectMeta)
+	}
+	if !equality.Semantic.DeepEqual(patched.Spec, want.Spec) {
+		t.Errorf("service spec = %+v, want %+v", patched.Spec, want.Spec)
+	}
+}
+
+func TestPatchHybridPairRenamesOwnRevisionName(t *testing.T) {
+	pair := hybridscalingv2.HybridPair{
+		Resources:    corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")},
+		Concurrency:  "20",
+		NumberOfPods: 12,
+	}
+	for _, tc := range []struct {
+		name   string
+		naming hybridscalingv2.RevisionNaming
+		pair   hybridscalingv2.HybridPair
+		want   string
+	}{
+		{name: "generate", naming: hybridscalingv2.RevisionNamingGenerate, pair: pair, want: "service-a-hybrid-00004"},
+		{name: "clear", naming: hybridscalingv2.RevisionNamingClear, pair: pair, want: ""},
+		// The template does not change, the revision keeps its name
+		{name: "unchanged", naming: hybridscalingv2.RevisionNamingGenerate, pair: hybridscalingv2.HybridPair{
+			Resources: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}, Concurrency: "10", NumberOfPods: 1}, want: "service-a-v7"},
+	} {
+		svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a", Generation: 3}}
+		svc.Spec.Template.Name = "service-a-v7"
+		svc.Spec.Template.Annotations = map[string]string{autoscaling.TargetAnnotationKey: "10", autoscaling.MinScaleAnnotationKey: "1",
+			autoscaling.InitialScaleAnnotationKey: "1"}
+		svc.Spec.Template.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+		}}}
+		r := &TrafficStatReconciler{Client: newTestClient(t, svc)}
+
+		live := &servingv1.Service{}
+		if err := r.Get(context.Background(), client.ObjectKeyFromObject(svc), live); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.patchHybridPair(context.Background(), live, tc.pair, nil, tc.naming); err != nil {
+			t.Fatalf("%s: patchHybridPair() error = %v", tc.name, err)
+		}
+		if err := r.Get(context.Background(), client.ObjectKeyFromObject(svc), live); err != nil {
+			t.Fatal(err)
+		}
+		if live.Spec.Template.Name != tc.want {
+			t.Errorf("%s: revision name = %q, want %q", tc.name, live.Spec.Template.Name, tc.want)
+		}
+	}
+}
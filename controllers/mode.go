@@ -0,0 +1,79 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+	"github.com/mipearlska/knative_hybrid_scaling/pkg/optimizer"
+)
+
+// scalingMode returns how decisions reach the target and, for an active override, how long until it expires.
+// Suspend wins over an override.
+func scalingMode(ts *hybridscalingv2.TrafficStat, now time.Time) (hybridscalingv2.ScalingMode, time.Duration) {
+	if ts.Spec.Suspend {
+		return hybridscalingv2.ScalingModeSuspended, 0
+	}
+	if override := ts.Spec.Override; override != nil {
+		if remaining := override.Until.Sub(now); remaining > 0 {
+			return hybridscalingv2.ScalingModeOverride, remaining
+		}
+	}
+	return hybridscalingv2.ScalingModePredictive, 0
+}
+
+// overrideDecision returns the decision pinned by the override, as the optimizer would have evaluated it.
+func overrideDecision(profile *hybridscalingv2.HybridScalingProfileSpec, override *hybridscalingv2.ScalingOverride) (optimizer.Decision, error) {
+	entry := hybridscalingv2.ProfileEntry{
+		Resources:          override.ResourceLevel,
+		ResourceList:       override.ResourceList,
+		OptimalConcurrency: override.Concurrency,
+	}
+	switch {
+	case override.Concurrency < 1:
+		return optimizer.Decision{}, errors.New("spec.override.concurrency must be at least 1")
+	case override.Pods < 0:
+		return optimizer.Decision{}, errors.New("spec.override.pods must not be negative")
+	case len(override.ResourceList) == 0 && override.ResourceLevel.Sign() <= 0:
+		return optimizer.Decision{}, errors.New("spec.override needs a positive resourceLevel or a resourceList")
+	case len(override.ResourceList) == 0 && profile.IntensiveResourceType == "":
+		return optimizer.Decision{}, fmt.Errorf("spec.override.resourceLevel %s needs the intensive resource type of a hybrid profile",
+			override.ResourceLevel.String())
+	}
+
+	resources := profile.EntryResources(&entry)
+	total := corev1.ResourceList{}
+	for name, quantity := range resources {
+		total[name] = *resource.NewMilliQuantity(quantity.MilliValue()*int64(override.Pods), quantity.Format)
+	}
+	return optimizer.Decision{Entry: entry, Resources: resources, Pods: override.Pods, Total: total}, nil
+}
+
+// requeueAtExpiry requeues the result no later than when the override expires, so predictive scaling resumes on time.
+func requeueAtExpiry(result ctrl.Result, remaining time.Duration) ctrl.Result {
+	if remaining > 0 && (result.RequeueAfter == 0 || remaining < result.RequeueAfter) {
+		result.RequeueAfter = remaining
+	}
+	return result
+}
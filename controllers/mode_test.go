@@ -0,0 +1,83 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestScalingMode(t *testing.T) {
+	now := time.Now()
+	override := func(until time.Time) *hybridscalingv2.ScalingOverride {
+		return &hybridscalingv2.ScalingOverride{ResourceLevel: resource.MustParse("2"), Concurrency: 10, Pods: 4, Until: metav1.NewTime(until)}
+	}
+	for _, tc := range []struct {
+		name          string
+		spec          hybridscalingv2.TrafficStatSpec
+		wantMode      hybridscalingv2.ScalingMode
+		wantRemaining time.Duration
+	}{
+		{name: "predictive", wantMode: hybridscalingv2.ScalingModePredictive},
+		{name: "override", spec: hybridscalingv2.TrafficStatSpec{Override: override(now.Add(time.Hour))},
+			wantMode: hybridscalingv2.ScalingModeOverride, wantRemaining: time.Hour},
+		{name: "expired override", spec: hybridscalingv2.TrafficStatSpec{Override: override(now.Add(-time.Minute))},
+			wantMode: hybridscalingv2.ScalingModePredictive},
+		{name: "suspended override", spec: hybridscalingv2.TrafficStatSpec{Suspend: true, Override: override(now.Add(time.Hour))},
+			wantMode: hybridscalingv2.ScalingModeSuspended},
+	} {
+		mode, remaining := scalingMode(&hybridscalingv2.TrafficStat{Spec: tc.spec}, now)
+		if mode != tc.wantMode || remaining != tc.wantRemaining {
+			t.Errorf("%s: scalingMode() = %s, %v, want %s, %v", tc.name, mode, remaining, tc.wantMode, tc.wantRemaining)
+		}
+	}
+
+	if got := requeueAtExpiry(ctrl.Result{RequeueAfter: time.Hour}, time.Minute); got.RequeueAfter != time.Minute {
+		t.Errorf("requeueAtExpiry() = %v, want 1m", got.RequeueAfter)
+	}
+	if got := requeueAtExpiry(ctrl.Result{RequeueAfter: time.Second}, time.Minute); got.RequeueAfter != time.Second {
+		t.Errorf("requeueAtExpiry() = %v, want 1s", got.RequeueAfter)
+	}
+}
+
+func TestOverrideDecision(t *testing.T) {
+	profile := &hybridscalingv2.HybridScalingProfileSpec{
+		IntensiveResourceType: corev1.ResourceCPU,
+		FixedResources:        corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("200Mi")},
+	}
+	decision, err := overrideDecision(profile, &hybridscalingv2.ScalingOverride{ResourceLevel: resource.MustParse("1500m"), Concurrency: 10, Pods: 4})
+	if err != nil {
+		t.Fatalf("overrideDecision() error = %v", err)
+	}
+	if memory := decision.Resources[corev1.ResourceMemory]; memory.Cmp(resource.MustParse("200Mi")) != 0 {
+		t.Errorf("memory = %s, want the profile's fixed 200Mi", memory.String())
+	}
+	if total := decision.Total[corev1.ResourceCPU]; decision.Pods != 4 || total.Cmp(resource.MustParse("6")) != 0 {
+		t.Errorf("decision = %d pods, %s cpu, want 4 pods, 6 cpu", decision.Pods, total.String())
+	}
+	if _, err := overrideDecision(&hybridscalingv2.HybridScalingProfileSpec{},
+		&hybridscalingv2.ScalingOverride{ResourceLevel: resource.MustParse("1500m"), Concurrency: 10}); err == nil {
+		t.Error("overrideDecision() accepted a resource level without a profile")
+	}
+}
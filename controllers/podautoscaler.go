@@ -0,0 +1,89 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+// readyPodAutoscaler returns the PodAutoscaler of the service's latest ready revision, nil when there is none yet.
+// Knative names the PodAutoscaler after its revision.
+func (r *TrafficStatReconciler) readyPodAutoscaler(ctx context.Context, svc *servingv1.Service) (*autoscalingv1alpha1.PodAutoscaler, error) {
+	if svc.Status.LatestReadyRevisionName == "" || svc.Status.LatestReadyRevisionName != svc.Status.LatestCreatedRevisionName {
+		return nil, nil
+	}
+	pa := &autoscalingv1alpha1.PodAutoscaler{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: svc.Namespace, Name: svc.Status.LatestReadyRevisionName}, pa)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return pa, err
+}
+
+// patchPodAutoscaler sets the given autoscaling annotations on the PodAutoscaler of a running revision.
+// KPA reads min-scale, max-scale and the other autoscaling knobs from these annotations and the revision
+// reconciler only keeps the PodAutoscaler's spec in sync, so the pod count changes without a new revision.
+func (r *TrafficStatReconciler) patchPodAutoscaler(ctx context.Context, pa *autoscalingv1alpha1.PodAutoscaler, annotations map[string]string) error {
+	original := pa.DeepCopy()
+	if pa.Annotations == nil {
+		pa.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		pa.Annotations[k] = v
+	}
+	patch := client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	return r.Patch(ctx, pa, patch, client.FieldOwner(FieldManager))
+}
+
+// restorePodAutoscaler puts the given autoscaling annotations back on the PodAutoscaler of a running revision.
+// Annotations with an empty value are removed.
+func (r *TrafficStatReconciler) restorePodAutoscaler(ctx context.Context, pa *autoscalingv1alpha1.PodAutoscaler, annotations map[string]string) error {
+	original := pa.DeepCopy()
+	for k, v := range annotations {
+		if v == "" {
+			delete(pa.Annotations, k)
+		} else if pa.Annotations == nil {
+			pa.Annotations = map[string]string{k: v}
+		} else {
+			pa.Annotations[k] = v
+		}
+	}
+	patch := client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	return r.Patch(ctx, pa, patch, client.FieldOwner(FieldManager))
+}
+
+// podAutoscalerSnapshot takes the annotations of the snapshot from the PodAutoscaler of the service's running revision,
+// where pod count changes patch min-scale and the managed annotations, so they may differ from the template.
+// The template values are kept when no revision is ready.
+func (r *TrafficStatReconciler) podAutoscalerSnapshot(ctx context.Context, svc *servingv1.Service, snapshot *hybridscalingv2.TemplateSnapshot) (*hybridscalingv2.TemplateSnapshot, error) {
+	pa, err := r.readyPodAutoscaler(ctx, svc)
+	if err != nil || pa == nil {
+		return snapshot, err
+	}
+	for k := range snapshot.Annotations {
+		snapshot.Annotations[k] = pa.Annotations[k]
+	}
+	return snapshot, nil
+}
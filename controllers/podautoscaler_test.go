@@ -0,0 +1,61 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"knative.dev/serving/pkg/apis/autoscaling"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestPodAutoscalerSnapshot(t *testing.T) {
+	// min-scale was moved from 4 to 8 on the PodAutoscaler by a pod count change, the template still says 4
+	pa := &autoscalingv1alpha1.PodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "service-a-00001", Namespace: "tenant-a",
+		Annotations: map[string]string{autoscaling.TargetAnnotationKey: "10", autoscaling.MinScaleAnnotationKey: "8"}}}
+	r := &TrafficStatReconciler{Client: newTestClient(t, pa)}
+	svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"}}
+	svc.Spec.Template.Annotations = map[string]string{autoscaling.TargetAnnotationKey: "10", autoscaling.MinScaleAnnotationKey: "4"}
+	svc.Status.LatestCreatedRevisionName = "service-a-00001"
+
+	for _, tc := range []struct {
+		name  string
+		ready string
+		want  string
+	}{
+		{name: "ready revision", ready: "service-a-00001", want: "8"},
+		{name: "no ready revision", ready: "", want: "4"},
+	} {
+		svc.Status.LatestReadyRevisionName = tc.ready
+		snapshot, err := r.podAutoscalerSnapshot(context.Background(), svc, templateSnapshot(svc, hybridscalingv2.HybridPair{}))
+		if err != nil {
+			t.Fatalf("%s: podAutoscalerSnapshot() error = %v", tc.name, err)
+		}
+		if got := snapshot.Annotations[autoscaling.MinScaleAnnotationKey]; got != tc.want {
+			t.Errorf("%s: min-scale = %q, want %q", tc.name, got, tc.want)
+		}
+		if got := snapshot.Annotations[autoscaling.TargetAnnotationKey]; got != "10" {
+			t.Errorf("%s: target = %q, want 10", tc.name, got)
+		}
+	}
+}
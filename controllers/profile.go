@@ -0,0 +1,169 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+const (
+	// legacyProfilePrefix prefixes the service name in the name of a legacy hybrid profile ConfigMap.
+	legacyProfilePrefix = "hybrid-"
+	// legacyIntensiveTypeKey and legacyRequiredResourcesKey are the non-entry keys of a legacy hybrid profile ConfigMap.
+	legacyIntensiveTypeKey     = "resources-intensive-type"
+	legacyRequiredResourcesKey = "required-resources"
+)
+
+// errInvalidProfile is wrapped by errors about profiles that cannot be used.
+var errInvalidProfile = errors.New("invalid hybrid profile")
+
+// profileName returns the name of the HybridScalingProfile used by the TrafficStat.
+func profileName(ts *hybridscalingv2.TrafficStat) string {
+	if ts.Spec.ProfileRef != nil && ts.Spec.ProfileRef.Name != "" {
+		return ts.Spec.ProfileRef.Name
+	}
+	return targetServiceName(ts)
+}
+
+// getProfile returns the hybrid profile of the TrafficStat's target and a description of where it was read from.
+// The HybridScalingProfile is preferred, the legacy "hybrid-<service>" ConfigMap is read when it does not exist.
+func (r *TrafficStatReconciler) getProfile(ctx context.Context, ts *hybridscalingv2.TrafficStat, namespace string) (*hybridscalingv2.HybridScalingProfileSpec, string, error) {
+	profile := &hybridscalingv2.HybridScalingProfile{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: profileName(ts)}, profile)
+	if err == nil {
+		if err := validateProfile(&profile.Spec); err != nil {
+			return nil, "", fmt.Errorf("HybridScalingProfile %s: %w", profile.Name, err)
+		}
+		return &profile.Spec, "HybridScalingProfile " + profile.Name, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, "", err
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: legacyProfilePrefix + targetServiceName(ts)}, cm); err != nil {
+		return nil, "", err
+	}
+	spec, err := profileFromConfigMap(cm)
+	if err != nil {
+		return nil, "", err
+	}
+	return spec, "legacy hybrid profile ConfigMap " + cm.Name, nil
+}
+
+// profileFromConfigMap migrates a legacy hybrid profile ConfigMap to a HybridScalingProfile spec.
+// Legacy entry keys are bare numbers in millicores for cpu and Mi for memory, or quantities with a unit.
+func profileFromConfigMap(cm *corev1.ConfigMap) (*hybridscalingv2.HybridScalingProfileSpec, error) {
+	spec := &hybridscalingv2.HybridScalingProfileSpec{
+		MeasuredFor: hybridscalingv2.ProfileSubject{ServiceName: cm.Name[len(legacyProfilePrefix):]},
+	}
+
+	var unit string
+	var fixed corev1.ResourceName
+	switch intensive := corev1.ResourceName(cm.Data[legacyIntensiveTypeKey]); intensive {
+	case corev1.ResourceCPU:
+		unit, fixed = "m", corev1.ResourceMemory
+	case corev1.ResourceMemory:
+		unit, fixed = "Mi", corev1.ResourceCPU
+	default:
+		return nil, fmt.Errorf("%w: ConfigMap %s: %s must be cpu or memory, got %q", errInvalidProfile, cm.Name, legacyIntensiveTypeKey, intensive)
+	}
+	spec.IntensiveResourceType = corev1.ResourceName(cm.Data[legacyIntensiveTypeKey])
+
+	if required, ok := cm.Data[legacyRequiredResourcesKey]; ok {
+		quantity, err := resource.ParseQuantity(required)
+		if err != nil {
+			return nil, fmt.Errorf("%w: ConfigMap %s: %s: %v", errInvalidProfile, cm.Name, legacyRequiredResourcesKey, err)
+		}
+		spec.FixedResources = corev1.ResourceList{fixed: quantity}
+	}
+
+	for level, concurrency := range cm.Data {
+		if level == legacyIntensiveTypeKey || level == legacyRequiredResourcesKey {
+			continue
+		}
+		quantity, err := parseLegacyLevel(level, unit)
+		if err != nil {
+			return nil, fmt.Errorf("%w: ConfigMap %s: level %q: %v", errInvalidProfile, cm.Name, level, err)
+		}
+		// Concurrencies were read as floats before profiles were typed, they are rounded to the nearest request
+		optimalConcurrency, err := strconv.ParseFloat(concurrency, 64)
+		if rounded := math.Round(optimalConcurrency); err != nil || !(rounded >= 1 && rounded <= math.MaxInt32) {
+			return nil, fmt.Errorf("%w: ConfigMap %s: key %q: concurrency %q must be a number of at least 1 request", errInvalidProfile, cm.Name, level, concurrency)
+		}
+		spec.Entries = append(spec.Entries, hybridscalingv2.ProfileEntry{
+			Resources:          quantity,
+			OptimalConcurrency: int32(math.Round(optimalConcurrency)),
+		})
+	}
+	// Map order is random, keep decisions between equal candidates stable
+	sort.Slice(spec.Entries, func(i, j int) bool {
+		return spec.Entries[i].Resources.Cmp(spec.Entries[j].Resources) < 0
+	})
+	return spec, validateProfile(spec)
+}
+
+// parseLegacyLevel parses a legacy entry key. Bare numbers are in the legacy unit (m or Mi),
+// keys with a unit such as "1.5", "500m" or "2Gi" are read as Kubernetes quantities.
+func parseLegacyLevel(level, unit string) (resource.Quantity, error) {
+	if _, err := strconv.ParseInt(level, 10, 64); err == nil {
+		level += unit
+	}
+	return resource.ParseQuantity(level)
+}
+
+// validateProfile checks the entries the schema cannot, so that a bad profile is reported instead of applied.
+func validateProfile(spec *hybridscalingv2.HybridScalingProfileSpec) error {
+	for i, entry := range spec.Entries {
+		if len(entry.ResourceList) > 0 {
+			if !entry.Resources.IsZero() {
+				return fmt.Errorf("%w: entry %d: set either resources or resourceList", errInvalidProfile, i)
+			}
+			for name, quantity := range entry.ResourceList {
+				if name != corev1.ResourceCPU && name != corev1.ResourceMemory && name != corev1.ResourceEphemeralStorage {
+					return fmt.Errorf("%w: entry %d: resourceList may hold cpu, memory and ephemeral-storage, got %q", errInvalidProfile, i, name)
+				}
+				if quantity.Sign() <= 0 {
+					return fmt.Errorf("%w: entry %d: %s %s must be positive", errInvalidProfile, i, name, quantity.String())
+				}
+			}
+		} else {
+			if spec.IntensiveResourceType != corev1.ResourceCPU && spec.IntensiveResourceType != corev1.ResourceMemory {
+				return fmt.Errorf("%w: intensive resource type must be cpu or memory, got %q", errInvalidProfile, spec.IntensiveResourceType)
+			}
+			if entry.Resources.Sign() <= 0 {
+				return fmt.Errorf("%w: entry %d: resources %s must be positive", errInvalidProfile, i, entry.Resources.String())
+			}
+		}
+		if entry.OptimalConcurrency < 1 {
+			return fmt.Errorf("%w: entry %d: optimal concurrency %d must be at least 1", errInvalidProfile, i, entry.OptimalConcurrency)
+		}
+	}
+	return nil
+}
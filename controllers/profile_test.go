@@ -0,0 +1,109 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestProfileFromConfigMap(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "hybrid-deploy-a"},
+		Data: map[string]string{
+			"resources-intensive-type": "cpu",
+			"required-resources":       "200Mi",
+			"2000m":                    "15",
+			"1000":                     "6",
+			"1.5":                      "9.5",
+		},
+	}
+	spec, err := profileFromConfigMap(cm)
+	if err != nil {
+		t.Fatalf("profileFromConfigMap() error = %v", err)
+	}
+	if spec.MeasuredFor.ServiceName != "deploy-a" || spec.IntensiveResourceType != corev1.ResourceCPU {
+		t.Errorf("profile = %+v, want cpu profile for deploy-a", spec)
+	}
+	if memory := spec.FixedResources[corev1.ResourceMemory]; memory.Cmp(resource.MustParse("200Mi")) != 0 {
+		t.Errorf("fixed memory = %s, want 200Mi", memory.String())
+	}
+	want := []struct {
+		level       string
+		concurrency int32
+	}{{"1000m", 6}, {"1500m", 10}, {"2000m", 15}}
+	if len(spec.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(spec.Entries), len(want))
+	}
+	for i, w := range want {
+		if got := spec.Entries[i]; got.Resources.Cmp(resource.MustParse(w.level)) != 0 || got.OptimalConcurrency != w.concurrency {
+			t.Errorf("entry %d = %s/%d, want %s/%d", i, got.Resources.String(), got.OptimalConcurrency, w.level, w.concurrency)
+		}
+	}
+}
+
+func TestProfileFromConfigMapRejectsUnknownType(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "hybrid-deploy-a"},
+		Data:       map[string]string{"resources-intensive-type": "gpu", "1": "1"},
+	}
+	if _, err := profileFromConfigMap(cm); !errors.Is(err, errInvalidProfile) {
+		t.Errorf("profileFromConfigMap() error = %v, want %v", err, errInvalidProfile)
+	}
+}
+
+func TestProfileFromConfigMapRejectsInvalidEntry(t *testing.T) {
+	for _, data := range []map[string]string{
+		{"resources-intensive-type": "cpu", "abc": "4"},
+		{"resources-intensive-type": "cpu", "1000": "four"},
+		{"resources-intensive-type": "cpu", "1000": "0.4"},
+		{"resources-intensive-type": "memory", "0": "4"},
+		{"resources-intensive-type": "memory", "required-resources": "1 cpu", "512": "4"},
+	} {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "hybrid-deploy-a"}, Data: data}
+		if _, err := profileFromConfigMap(cm); !errors.Is(err, errInvalidProfile) {
+			t.Errorf("profileFromConfigMap(%v) error = %v, want %v", data, err, errInvalidProfile)
+		}
+	}
+}
+
+func TestValidateProfileResourceList(t *testing.T) {
+	entry := func(resources corev1.ResourceList) hybridscalingv2.ProfileEntry {
+		return hybridscalingv2.ProfileEntry{ResourceList: resources, OptimalConcurrency: 10}
+	}
+	valid := &hybridscalingv2.HybridScalingProfileSpec{Entries: []hybridscalingv2.ProfileEntry{
+		entry(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}),
+	}}
+	if err := validateProfile(valid); err != nil {
+		t.Errorf("validateProfile(cpu and memory) error = %v, want none", err)
+	}
+	for _, resources := range []corev1.ResourceList{
+		{corev1.ResourceCPU: resource.MustParse("0")},
+		{corev1.ResourceName("nvidia.com/gpu"): resource.MustParse("1")},
+	} {
+		spec := &hybridscalingv2.HybridScalingProfileSpec{Entries: []hybridscalingv2.ProfileEntry{entry(resources)}}
+		if err := validateProfile(spec); !errors.Is(err, errInvalidProfile) {
+			t.Errorf("validateProfile(%v) error = %v, want %v", resources, err, errInvalidProfile)
+		}
+	}
+}
@@ -0,0 +1,545 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/pkg/apis"
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+	"github.com/mipearlska/knative_hybrid_scaling/pkg/optimizer"
+)
+
+const (
+	// revisionPollInterval is how often the new revision is checked while waiting for it to become Ready.
+	revisionPollInterval = 1 * time.Second
+	// maxReadyRevisionBackoff bounds the requeue interval while a pod count change waits for a ready revision.
+	// The Revision watch reconciles the TrafficStat as soon as it becomes ready, the requeue only covers missed events.
+	maxReadyRevisionBackoff = 1 * time.Minute
+	// defaultRevisionReadyTimeout is how long a new revision may take to become Ready when no timeout is configured.
+	// It matches Knative's default progress deadline.
+	defaultRevisionReadyTimeout = 10 * time.Minute
+	// drainDelay is how long the new revision serves before the previous one is removed.
+	drainDelay = 5 * time.Second
+	// revisionDeleteDelay is how often the removal of the previous revision is checked.
+	revisionDeleteDelay = 2 * time.Second
+	// defaultStepInterval is how long each step of a Gradual rollout serves when the policy does not say otherwise.
+	defaultStepInterval = 30 * time.Second
+	// defaultFailureBackoff is how long a level whose rollout failed is not chosen again when the policy does not say otherwise.
+	defaultFailureBackoff = 30 * time.Minute
+)
+
+// defaultSteps are the traffic percentages of a Gradual rollout when the policy does not list any.
+var defaultSteps = []int32{20, 50, 100}
+
+// rolloutPolicy returns the RolloutPolicy of the TrafficStat, else of its profile.
+func rolloutPolicy(ts *hybridscalingv2.TrafficStat, profile *hybridscalingv2.HybridScalingProfileSpec) *hybridscalingv2.RolloutPolicy {
+	if ts.Spec.RolloutPolicy != nil {
+		return ts.Spec.RolloutPolicy
+	}
+	return profile.RolloutPolicy
+}
+
+// rolloutSteps returns the increasing traffic percentages of a Gradual rollout, ending with 100.
+// Percentages out of range or not above the previous one are dropped.
+func rolloutSteps(steps []int32) []int32 {
+	if len(steps) == 0 {
+		steps = defaultSteps
+	}
+	normalized := make([]int32, 0, len(steps)+1)
+	for _, step := range steps {
+		if step <= 0 || step > 100 || (len(normalized) > 0 && step <= normalized[len(normalized)-1]) {
+			continue
+		}
+		normalized = append(normalized, step)
+	}
+	if len(normalized) == 0 || normalized[len(normalized)-1] != 100 {
+		normalized = append(normalized, 100)
+	}
+	return normalized
+}
+
+// startRollout records the switch to a new pair in the TrafficStat status.
+// The status is written before the Service is touched so an interrupted rollout is resumed after a restart,
+// together with the original settings of what the pair changes.
+// A Gradual rollout needs a previous revision and the Service's default routing, else the pair replaces it.
+func (r *TrafficStatReconciler) startRollout(ctx context.Context, ts *hybridscalingv2.TrafficStat, svc *servingv1.Service, pair hybridscalingv2.HybridPair, policy *hybridscalingv2.RolloutPolicy) error {
+	if err := r.recordOriginal(ctx, ts, svc, pairAnnotationKeys(pair), resourceNames(pair.Resources)); err != nil {
+		return err
+	}
+	knownGood, err := r.podAutoscalerSnapshot(ctx, svc, templateSnapshot(svc, pair))
+	if err != nil {
+		return err
+	}
+	now := metav1.Now()
+	ts.Status.Rollout = &hybridscalingv2.RolloutStatus{
+		Phase:              hybridscalingv2.RolloutPhaseApplying,
+		Pair:               pair,
+		PreviousRevision:   svc.Status.LatestReadyRevisionName,
+		Strategy:           hybridscalingv2.RolloutStrategyReplace,
+		KnownGood:          knownGood,
+		LastTransitionTime: &now,
+	}
+	if policy != nil && policy.Strategy == hybridscalingv2.RolloutStrategyGradual {
+		if svc.Status.LatestReadyRevisionName != "" && hasDefaultTraffic(svc) {
+			ts.Status.Rollout.Strategy = hybridscalingv2.RolloutStrategyGradual
+			ts.Status.Rollout.Steps = rolloutSteps(policy.Steps)
+			ts.Status.Rollout.StepInterval = &metav1.Duration{Duration: defaultStepInterval}
+			if policy.StepInterval != nil {
+				ts.Status.Rollout.StepInterval = policy.StepInterval.DeepCopy()
+			}
+		} else {
+			loggerSD.Info("Gradual rollout needs a ready revision receiving all traffic, replacing instead", "SERVICE_NAME", svc.Name)
+		}
+	}
+	setCondition(ts, hybridscalingv2.ConditionRevisionReady, metav1.ConditionUnknown, "Applying", "applying the chosen pair to the Knative Service")
+	return r.Status().Update(ctx, ts)
+}
+
+// reconcileRollout moves the rollout recorded in the TrafficStat status one phase forward.
+// No phase blocks: each one returns right away and asks to be requeued,
+// so a revision that never gets ready does not hold up the rollouts of other services.
+// The updated rollout status is written by Reconcile.
+func (r *TrafficStatReconciler) reconcileRollout(ctx context.Context, ts *hybridscalingv2.TrafficStat, svc *servingv1.Service, profile *hybridscalingv2.HybridScalingProfileSpec) (ctrl.Result, error) {
+	rollout := ts.Status.Rollout
+	deadline := r.rolloutDeadline(rolloutPolicy(ts, profile))
+
+	switch rollout.Phase {
+	case hybridscalingv2.RolloutPhaseApplying:
+		//// Patch the current service, Knative creates a new Service Revision from the updated Configuration
+		loggerSD.Info("Patching Configuration of service ", "SERVICE_NAME", svc.Name)
+		// A Gradual rollout keeps all traffic on the previous revision until the new one is Ready
+		var traffic []servingv1.TrafficTarget
+		if rollout.Strategy == hybridscalingv2.RolloutStrategyGradual {
+			traffic = splitTraffic(rollout.PreviousRevision, "", 0)
+		}
+		if err := r.patchHybridPair(ctx, svc, rollout.Pair, traffic, revisionNaming(rolloutPolicy(ts, profile))); err != nil {
+			return ctrl.Result{}, err
+		}
+		rollout.ServiceGeneration = svc.Generation
+		loggerSD.Info("Service Configuration patched", "SERVICE", svc.Name, "GENERATION", svc.Generation)
+		setCondition(ts, hybridscalingv2.ConditionRevisionReady, metav1.ConditionUnknown, "Waiting",
+			fmt.Sprintf("waiting for the revision of Service generation %d", svc.Generation))
+		return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseWaitingForRevision, revisionPollInterval)
+
+	case hybridscalingv2.RolloutPhaseWaitingForRevision:
+		// Keep previous Revision alive until the Revision created for the patched generation is Ready
+		ready, reason, message, err := r.newRevisionReady(ctx, svc, rollout)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if reason != "" {
+			return r.rollBack(ctx, ts, svc, profile, reason, message)
+		}
+		if !ready {
+			loggerSD.Info("New Revision NOT READY", "REV_NAME", rollout.NewRevision)
+			if rollout.LastTransitionTime != nil && time.Since(rollout.LastTransitionTime.Time) > deadline {
+				return r.rollBack(ctx, ts, svc, profile, "RevisionReadyTimeout",
+					fmt.Sprintf("revision %q did not become ready within %s", rollout.NewRevision, deadline))
+			}
+			return ctrl.Result{RequeueAfter: revisionPollInterval}, nil
+		}
+		loggerSD.Info("New Revision Ready", "REV_NAME", rollout.NewRevision)
+		setCondition(ts, hybridscalingv2.ConditionRevisionReady, metav1.ConditionTrue, "Ready", "revision "+rollout.NewRevision+" is Ready")
+		if rollout.Strategy == hybridscalingv2.RolloutStrategyGradual && rollout.NewRevision != rollout.PreviousRevision {
+			setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionFalse, "ShiftingTraffic",
+				"shifting traffic from revision "+rollout.PreviousRevision+" to "+rollout.NewRevision)
+			setAppliedRevision(ts, rollout.NewRevision)
+			return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseShifting, 0)
+		}
+		setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionFalse, "Draining", "removing revision "+rollout.PreviousRevision)
+		setAppliedRevision(ts, rollout.NewRevision)
+		return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseDraining, drainDelay)
+
+	case hybridscalingv2.RolloutPhaseShifting:
+		if rollout.TrafficPercent > 0 && rollout.LastTransitionTime != nil {
+			if wait := stepInterval(rollout) - time.Since(rollout.LastTransitionTime.Time); wait > 0 {
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+		}
+		next := nextStep(rollout.Steps, rollout.TrafficPercent)
+		// The new revision takes the next share once it has the pods to serve it
+		readyPods, err := r.revisionReadyPods(ctx, svc.Namespace, rollout.NewRevision)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if needed := podsForShare(rollout.Pair.NumberOfPods, next); readyPods < needed {
+			loggerSD.Info("Waiting for new Revision pods", "REV_NAME", rollout.NewRevision, "READY", readyPods, "NEEDED", needed, "NEXT_PERCENT", next)
+			if rollout.LastTransitionTime != nil && stepWaited(rollout, time.Now()) > deadline {
+				return r.rollBack(ctx, ts, svc, profile, "StepPodsTimeout",
+					fmt.Sprintf("revision %q did not get %d ready pods for %d%% of the traffic within %s", rollout.NewRevision, needed, next, deadline))
+			}
+			return ctrl.Result{RequeueAfter: revisionPollInterval}, nil
+		}
+		if err := r.patchTraffic(ctx, svc, splitTraffic(rollout.PreviousRevision, rollout.NewRevision, next)); err != nil {
+			return ctrl.Result{}, err
+		}
+		loggerSD.Info("Shifted traffic to new Revision", "REV_NAME", rollout.NewRevision, "PERCENT", next)
+		rollout.TrafficPercent = next
+		if next < 100 {
+			setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionFalse, "ShiftingTraffic",
+				fmt.Sprintf("%d%% of the traffic is routed to revision %s", next, rollout.NewRevision))
+			return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseShifting, stepInterval(rollout))
+		}
+		// The previous revision no longer receives traffic, it is removed as the cleanup policy says
+		setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionFalse, "Draining", "removing revision "+rollout.PreviousRevision)
+		return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseDraining, drainDelay)
+
+	case hybridscalingv2.RolloutPhaseDraining:
+		if rollout.LastTransitionTime != nil {
+			if wait := drainDelay - time.Since(rollout.LastTransitionTime.Time); wait > 0 {
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+		}
+		if rollout.PreviousRevision == rollout.NewRevision {
+			// The pair was applied without a new revision, there is nothing to drain
+			setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionTrue, "NoOldRevision", "revision "+rollout.NewRevision+" was kept")
+			return r.completeRollout(ts)
+		}
+		cleanup := cleanupPolicy(rolloutPolicy(ts, profile))
+		if cleanup == hybridscalingv2.CleanupKnativeGC {
+			setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionTrue, "Unrouted",
+				"revision "+rollout.PreviousRevision+" no longer receives traffic and is left to Knative's garbage collection")
+			return r.completeRollout(ts)
+		}
+		cleaned, err := r.cleanupRevision(ctx, ts, svc.Namespace, rollout.PreviousRevision, cleanup)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !cleaned {
+			// A cleanup held for longer than the deadline does not hold up the next decisions, the revision is left to Knative
+			if rollout.LastTransitionTime != nil && time.Since(rollout.LastTransitionTime.Time) > drainDelay+deadline {
+				message := fmt.Sprintf("revision %s was not removed within %s (%s), it is left to Knative's garbage collection",
+					rollout.PreviousRevision, deadline, cleanup)
+				loggerSD.Info("Revision cleanup timed out", "REVISION_NAME", rollout.PreviousRevision, "CLEANUP", cleanup)
+				r.event(ts, corev1.EventTypeWarning, "CleanupTimeout", "%s", message)
+				setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionFalse, "CleanupTimeout", message)
+				return r.completeRollout(ts)
+			}
+			return ctrl.Result{RequeueAfter: revisionDeleteDelay}, nil
+		}
+		setCondition(ts, hybridscalingv2.ConditionOldRevisionCleaned, metav1.ConditionTrue, "Cleaned",
+			fmt.Sprintf("revision %s was removed (%s)", rollout.PreviousRevision, cleanup))
+		return r.completeRollout(ts)
+
+	case hybridscalingv2.RolloutPhaseRollingBack:
+		// The restored template serves once the Service's latest created revision is Ready
+		restored := svc.Status.ObservedGeneration >= rollout.ServiceGeneration && svc.Status.LatestCreatedRevisionName != "" &&
+			svc.Status.LatestReadyRevisionName == svc.Status.LatestCreatedRevisionName
+		if !restored {
+			if rollout.LastTransitionTime == nil || time.Since(rollout.LastTransitionTime.Time) <= deadline {
+				return ctrl.Result{RequeueAfter: revisionPollInterval}, nil
+			}
+			// Traffic stays on the previous revision, the Service owner has to look into it
+			loggerSD.Info("Restored Revision NOT READY", "SERVICE_NAME", svc.Name, "REV_NAME", svc.Status.LatestCreatedRevisionName)
+			rollout.Message += fmt.Sprintf("; the restored template did not become ready within %s", deadline)
+			setCondition(ts, hybridscalingv2.ConditionRolloutFailed, metav1.ConditionTrue, rollout.Reason, rollout.Message)
+			return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseFailed, 0)
+		}
+		if rollout.Strategy == hybridscalingv2.RolloutStrategyGradual {
+			if err := r.patchTraffic(ctx, svc, splitTraffic("", "", 100)); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		loggerSD.Info("Rolled back Service", "SERVICE_NAME", svc.Name, "REV_NAME", svc.Status.LatestReadyRevisionName)
+		return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseFailed, 0)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// rollBack restores the template the Service had before the rollout and blocks the level of the pair
+// for the failure backoff of the policy. The reason reported is taken from the pods or conditions of the
+// new revision when they tell why it is not Ready. A Gradual rollout routes all traffic to the previous
+// revision until the restored template is Ready.
+func (r *TrafficStatReconciler) rollBack(ctx context.Context, ts *hybridscalingv2.TrafficStat, svc *servingv1.Service, profile *hybridscalingv2.HybridScalingProfileSpec, reason, message string) (ctrl.Result, error) {
+	rollout := ts.Status.Rollout
+	failureReason, failureMessage, err := r.revisionFailure(ctx, svc.Namespace, rollout.NewRevision)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if failureReason != "" {
+		reason = failureReason
+		message = fmt.Sprintf("revision %q: %s", rollout.NewRevision, failureMessage)
+	}
+	loggerSD.Info("New Revision FAILED", "REV_NAME", rollout.NewRevision, "REASON", reason, "MESSAGE", message)
+	rollout.Reason = reason
+	rollout.Message = message
+	setCondition(ts, hybridscalingv2.ConditionRevisionReady, metav1.ConditionFalse, reason, message)
+	setCondition(ts, hybridscalingv2.ConditionRolloutFailed, metav1.ConditionTrue, reason, message)
+
+	if len(rollout.Pair.Resources) > 0 {
+		until := metav1.NewTime(time.Now().Add(failureBackoff(rolloutPolicy(ts, profile))))
+		blockLevel(ts, rollout.Pair.Resources, until, reason)
+		loggerSD.Info("Blocked resource level", "LEVEL", optimizer.FormatResources(rollout.Pair.Resources), "UNTIL", until.String())
+	}
+
+	if rollout.KnownGood == nil {
+		return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseFailed, 0)
+	}
+	var traffic []servingv1.TrafficTarget
+	if rollout.Strategy == hybridscalingv2.RolloutStrategyGradual {
+		traffic = splitTraffic(rollout.PreviousRevision, "", 0)
+	}
+	if err := r.restoreTemplate(ctx, svc, rollout.KnownGood, traffic, revisionNaming(rolloutPolicy(ts, profile))); err != nil {
+		return ctrl.Result{}, err
+	}
+	rollout.ServiceGeneration = svc.Generation
+	loggerSD.Info("Restored previous Service Configuration", "SERVICE_NAME", svc.Name, "GENERATION", svc.Generation)
+	return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseRollingBack, revisionPollInterval)
+}
+
+// completeRollout marks the rollout Done.
+func (r *TrafficStatReconciler) completeRollout(ts *hybridscalingv2.TrafficStat) (ctrl.Result, error) {
+	setCondition(ts, hybridscalingv2.ConditionRolloutFailed, metav1.ConditionFalse, "RolledOut",
+		"revision "+ts.Status.Rollout.NewRevision+" runs the chosen pair")
+	return r.setRolloutPhase(ts, hybridscalingv2.RolloutPhaseDone, 0)
+}
+
+// stepWaited returns how long the next Gradual step has been waiting for the pods of the new revision.
+// A step is due once the previous one served its interval.
+func stepWaited(rollout *hybridscalingv2.RolloutStatus, now time.Time) time.Duration {
+	waited := now.Sub(rollout.LastTransitionTime.Time)
+	if rollout.TrafficPercent > 0 {
+		waited -= stepInterval(rollout)
+	}
+	return waited
+}
+
+// stepInterval returns how long each step of a Gradual rollout serves.
+func stepInterval(rollout *hybridscalingv2.RolloutStatus) time.Duration {
+	if rollout.StepInterval != nil {
+		return rollout.StepInterval.Duration
+	}
+	return defaultStepInterval
+}
+
+// nextStep returns the first step above the current traffic percentage.
+func nextStep(steps []int32, current int32) int32 {
+	for _, step := range steps {
+		if step > current {
+			return step
+		}
+	}
+	return 100
+}
+
+// podsForShare returns how many ready pods the new revision needs to take percent of the traffic,
+// given the pod count of the pair. At least one pod is needed.
+func podsForShare(numberOfPods, percent int32) int32 {
+	if numberOfPods < 1 {
+		return 1
+	}
+	needed := int32(math.Ceil(float64(numberOfPods) * float64(percent) / 100))
+	if needed < 1 {
+		return 1
+	}
+	return needed
+}
+
+// revisionReadyPods returns the number of ready pods of a revision, as reported by its PodAutoscaler.
+func (r *TrafficStatReconciler) revisionReadyPods(ctx context.Context, namespace, revision string) (int32, error) {
+	rev := &servingv1.Revision{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: revision}, rev); err != nil {
+		return 0, client.IgnoreNotFound(err)
+	}
+	if rev.Status.ActualReplicas == nil {
+		return 0, nil
+	}
+	return *rev.Status.ActualReplicas, nil
+}
+
+// setRolloutPhase moves the rollout to the given phase and requeues the TrafficStat after the given delay.
+func (r *TrafficStatReconciler) setRolloutPhase(ts *hybridscalingv2.TrafficStat, phase hybridscalingv2.RolloutPhase, requeueAfter time.Duration) (ctrl.Result, error) {
+	now := metav1.Now()
+	ts.Status.Rollout.Phase = phase
+	ts.Status.Rollout.LastTransitionTime = &now
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// rolloutDeadline returns how long a new revision may take to become Ready, each Gradual step to get its pods,
+// and the previous revision to be cleaned up.
+func (r *TrafficStatReconciler) rolloutDeadline(policy *hybridscalingv2.RolloutPolicy) time.Duration {
+	if policy != nil && policy.Deadline != nil {
+		return policy.Deadline.Duration
+	}
+	return r.revisionReadyTimeout()
+}
+
+// failureBackoff returns how long a level whose rollout failed is not chosen again.
+func failureBackoff(policy *hybridscalingv2.RolloutPolicy) time.Duration {
+	if policy != nil && policy.FailureBackoff != nil {
+		return policy.FailureBackoff.Duration
+	}
+	return defaultFailureBackoff
+}
+
+// blockLevel leaves the level out of the decisions until the given time.
+// A level that is blocked again gets the new expiry.
+func blockLevel(ts *hybridscalingv2.TrafficStat, resources corev1.ResourceList, until metav1.Time, reason string) {
+	for i := range ts.Status.BlockedLevels {
+		if optimizer.SameResources(ts.Status.BlockedLevels[i].Resources, resources) {
+			ts.Status.BlockedLevels[i].Until = until
+			ts.Status.BlockedLevels[i].Reason = reason
+			return
+		}
+	}
+	ts.Status.BlockedLevels = append(ts.Status.BlockedLevels, hybridscalingv2.BlockedLevel{Resources: resources.DeepCopy(), Until: until, Reason: reason})
+}
+
+// withoutBlockedLevels drops the expired levels from the TrafficStat status and returns the profile
+// without the entries of the levels that are still blocked, and when the next of them expires.
+func withoutBlockedLevels(ts *hybridscalingv2.TrafficStat, profile *hybridscalingv2.HybridScalingProfileSpec, now time.Time) (*hybridscalingv2.HybridScalingProfileSpec, time.Duration) {
+	var blocked []hybridscalingv2.BlockedLevel
+	var nextExpiry time.Duration
+	for _, b := range ts.Status.BlockedLevels {
+		if !b.Until.Time.After(now) {
+			continue
+		}
+		blocked = append(blocked, b)
+		if expiry := b.Until.Time.Sub(now); nextExpiry == 0 || expiry < nextExpiry {
+			nextExpiry = expiry
+		}
+	}
+	ts.Status.BlockedLevels = blocked
+	if len(blocked) == 0 {
+		return profile, 0
+	}
+
+	available := profile.DeepCopy()
+	available.Entries = available.Entries[:0]
+	for i := range profile.Entries {
+		entry := &profile.Entries[i]
+		isBlocked := false
+		for _, b := range blocked {
+			if optimizer.SameResources(b.Resources, profile.EntryResources(entry)) {
+				isBlocked = true
+				break
+			}
+		}
+		if !isBlocked {
+			available.Entries = append(available.Entries, *entry.DeepCopy())
+		}
+	}
+	return available, nextExpiry
+}
+
+// revisionReadyTimeout returns how long a new revision may take to become Ready.
+func (r *TrafficStatReconciler) revisionReadyTimeout() time.Duration {
+	if r.RevisionReadyTimeout > 0 {
+		return r.RevisionReadyTimeout
+	}
+	return defaultRevisionReadyTimeout
+}
+
+// newRevisionReady looks up the revision Knative created for the rollout's Service generation
+// and reports whether its Ready condition is True. A non-empty reason means the revision failed.
+// The revision name is taken from the Service's latestCreatedRevisionName once the Service has
+// observed the patched generation, so custom revision names and concurrent updates are handled.
+func (r *TrafficStatReconciler) newRevisionReady(ctx context.Context, svc *servingv1.Service, rollout *hybridscalingv2.RolloutStatus) (ready bool, reason, message string, err error) {
+	if svc.Status.ObservedGeneration < rollout.ServiceGeneration || svc.Status.LatestCreatedRevisionName == "" {
+		return false, "", "", nil
+	}
+	if svc.Status.LatestCreatedRevisionName == rollout.PreviousRevision {
+		// The patch did not change the template, the previous revision already runs the pair
+		rollout.NewRevision = rollout.PreviousRevision
+		return true, "", "", nil
+	}
+	rollout.NewRevision = svc.Status.LatestCreatedRevisionName
+
+	newRevision := &servingv1.Revision{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: svc.Namespace, Name: rollout.NewRevision}, newRevision); err != nil {
+		return false, "", "", client.IgnoreNotFound(err)
+	}
+	if newRevision.Status.ObservedGeneration != newRevision.Generation {
+		return false, "", "", nil
+	}
+	cond := newRevision.Status.GetCondition(servingv1.RevisionConditionReady)
+	switch {
+	case cond.IsTrue():
+		return true, "", "", nil
+	case cond.IsFalse():
+		reason = cond.Reason
+		if reason == "" {
+			reason = "RevisionFailed"
+		}
+		return false, reason, cond.Message, nil
+	}
+	return false, "", "", nil
+}
+
+// revisionFailure tells why a revision is not Ready. Its pods name the cause best (Unschedulable,
+// ImagePullBackOff, CrashLoopBackOff...), then the revision's own conditions. An empty reason means none is known.
+func (r *TrafficStatReconciler) revisionFailure(ctx context.Context, namespace, revision string) (reason, message string, err error) {
+	if revision == "" {
+		return "", "", nil
+	}
+	RevisionPodList := &corev1.PodList{}
+	if err := r.List(ctx, RevisionPodList, client.InNamespace(namespace), client.MatchingLabels{serving.RevisionLabelKey: revision}); err != nil {
+		return "", "", err
+	}
+	for i := range RevisionPodList.Items {
+		if reason, message := podFailure(&RevisionPodList.Items[i]); reason != "" {
+			return reason, message, nil
+		}
+	}
+
+	rev := &servingv1.Revision{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: revision}, rev); err != nil {
+		return "", "", client.IgnoreNotFound(err)
+	}
+	for _, conditionType := range []apis.ConditionType{
+		servingv1.RevisionConditionContainerHealthy,
+		servingv1.RevisionConditionResourcesAvailable,
+		servingv1.RevisionConditionReady,
+	} {
+		if cond := rev.Status.GetCondition(conditionType); cond.IsFalse() && cond.Reason != "" {
+			return cond.Reason, cond.Message, nil
+		}
+	}
+	return "", "", nil
+}
+
+// podFailure returns why a pod does not run: the reason it cannot be scheduled,
+// else the reason one of its containers is waiting on, other than being created.
+func podFailure(pod *corev1.Pod) (reason, message string) {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && cond.Reason != "" {
+			return cond.Reason, fmt.Sprintf("pod %s: %s", pod.Name, cond.Message)
+		}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting == nil || waiting.Reason == "" || waiting.Reason == "ContainerCreating" || waiting.Reason == "PodInitializing" {
+			continue
+		}
+		return waiting.Reason, fmt.Sprintf("container %s of pod %s: %s", status.Name, pod.Name, waiting.Message)
+	}
+	return "", ""
+}
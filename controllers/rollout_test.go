@@ -0,0 +1,431 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/serving/pkg/apis/autoscaling"
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+	"github.com/mipearlska/knative_hybrid_scaling/pkg/optimizer"
+)
+
+func TestRolloutSteps(t *testing.T) {
+	for _, tc := range []struct {
+		steps []int32
+		want  []int32
+	}{
+		{steps: nil, want: []int32{20, 50, 100}},
+		{steps: []int32{10, 30}, want: []int32{10, 30, 100}},
+		{steps: []int32{50, 20, 0, 120, 100}, want: []int32{50, 100}},
+	} {
+		if got := rolloutSteps(tc.steps); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("rolloutSteps(%v) = %v, want %v", tc.steps, got, tc.want)
+		}
+	}
+}
+
+func TestGradualShift(t *testing.T) {
+	steps := rolloutSteps(nil)
+	if got := nextStep(steps, 0); got != 20 {
+		t.Errorf("nextStep(0) = %d, want 20", got)
+	}
+	if got := nextStep(steps, 50); got != 100 {
+		t.Errorf("nextStep(50) = %d, want 100", got)
+	}
+	// 20% of 12 pods needs 3 ready pods of the new revision
+	if got := podsForShare(12, 20); got != 3 {
+		t.Errorf("podsForShare(12, 20) = %d, want 3", got)
+	}
+	if got := podsForShare(0, 20); got != 1 {
+		t.Errorf("podsForShare(0, 20) = %d, want 1", got)
+	}
+
+	split := splitTraffic("svc-00001", "svc-00002", 20)
+	if len(split) != 2 || split[0].RevisionName != "svc-00001" || *split[0].Percent != 80 ||
+		split[1].RevisionName != "svc-00002" || *split[1].Percent != 20 {
+		t.Errorf("splitTraffic(20) = %+v, want 80%% on svc-00001 and 20%% on svc-00002", split)
+	}
+	if done := splitTraffic("svc-00001", "svc-00002", 100); len(done) != 1 || !*done[0].LatestRevision || *done[0].Percent != 100 {
+		t.Errorf("splitTraffic(100) = %+v, want all traffic on the latest revision", done)
+	}
+}
+
+func TestPodFailure(t *testing.T) {
+	unschedulable := &corev1.Pod{Status: corev1.PodStatus{Conditions: []corev1.PodCondition{
+		{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable", Message: "0/3 nodes are available: 3 Insufficient cpu."},
+	}}}
+	if reason, _ := podFailure(unschedulable); reason != "Unschedulable" {
+		t.Errorf("podFailure(unschedulable) = %q, want Unschedulable", reason)
+	}
+	crashing := &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+		{Name: "queue-proxy", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		{Name: "user-container", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+	}}}
+	if reason, _ := podFailure(crashing); reason != "CrashLoopBackOff" {
+		t.Errorf("podFailure(crashing) = %q, want CrashLoopBackOff", reason)
+	}
+	starting := &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+		{Name: "user-container", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+	}}}
+	if reason, _ := podFailure(starting); reason != "" {
+		t.Errorf("podFailure(starting) = %q, want no reason", reason)
+	}
+}
+
+func TestBlockedLevels(t *testing.T) {
+	now := time.Now()
+	profile := &hybridscalingv2.HybridScalingProfileSpec{
+		IntensiveResourceType: corev1.ResourceCPU,
+		FixedResources:        corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("200Mi")},
+		Entries: []hybridscalingv2.ProfileEntry{
+			{Resources: resource.MustParse("1000m"), OptimalConcurrency: 6},
+			{Resources: resource.MustParse("1500m"), OptimalConcurrency: 10},
+		},
+	}
+	level := func(cpu string) corev1.ResourceList {
+		return corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse("200Mi")}
+	}
+	ts := &hybridscalingv2.TrafficStat{}
+	blockLevel(ts, level("1.5"), metav1.NewTime(now.Add(time.Minute)), "ImagePullBackOff")
+	blockLevel(ts, level("1000m"), metav1.NewTime(now.Add(-time.Minute)), "Unschedulable")
+
+	available, nextUnblock := withoutBlockedLevels(ts, profile, now)
+	if len(available.Entries) != 1 || available.Entries[0].OptimalConcurrency != 6 {
+		t.Errorf("available entries = %+v, want the 1000m entry only", available.Entries)
+	}
+	if nextUnblock != time.Minute {
+		t.Errorf("next unblock = %s, want 1m", nextUnblock)
+	}
+	if len(ts.Status.BlockedLevels) != 1 || ts.Status.BlockedLevels[0].Reason != "ImagePullBackOff" {
+		t.Errorf("blocked levels = %+v, want the expired level dropped", ts.Status.BlockedLevels)
+	}
+	if len(profile.Entries) != 2 {
+		t.Errorf("profile entries = %+v, want the profile left untouched", profile.Entries)
+	}
+}
+
+// generationClient bumps the generation of a Knative Service whose spec is patched, as the API server does.
+type generationClient struct {
+	client.Client
+}
+
+func (c generationClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	svc, ok := obj.(*servingv1.Service)
+	if !ok {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	before := &servingv1.Service{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(svc), before); err != nil {
+		return err
+	}
+	if err := c.Client.Patch(ctx, svc, patch, opts...); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(before.Spec, svc.Spec) {
+		return nil
+	}
+	svc.Generation = before.Generation + 1
+	return c.Update(ctx, svc)
+}
+
+// rolloutFixture is a Knative Service running revision service-a-00001 with concurrency 10 on 500m,
+// being switched to concurrency 20 on 1500m.
+type rolloutFixture struct {
+	t       *testing.T
+	r       *TrafficStatReconciler
+	ts      *hybridscalingv2.TrafficStat
+	profile *hybridscalingv2.HybridScalingProfileSpec
+}
+
+func newRolloutFixture(t *testing.T, rollout hybridscalingv2.RolloutStatus, objects ...client.Object) *rolloutFixture {
+	svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a", Generation: 1}}
+	svc.Spec.Template.Annotations = map[string]string{autoscaling.TargetAnnotationKey: "10", autoscaling.MinScaleAnnotationKey: "4"}
+	svc.Spec.Template.Spec.Containers = []corev1.Container{{Image: "registry.example.com/app:v1", Resources: cpuResources("500m")}}
+	svc.Status.ObservedGeneration = 1
+	svc.Status.LatestCreatedRevisionName = "service-a-00001"
+	svc.Status.LatestReadyRevisionName = "service-a-00001"
+
+	if rollout.Pair.Resources == nil {
+		rollout.Pair = hybridscalingv2.HybridPair{Resources: cpuResources("1500m").Limits, Concurrency: "20", NumberOfPods: 4}
+	}
+	if rollout.PreviousRevision == "" {
+		rollout.PreviousRevision = "service-a-00001"
+	}
+	if rollout.KnownGood == nil {
+		rollout.KnownGood = templateSnapshot(svc, rollout.Pair)
+	}
+	if rollout.LastTransitionTime == nil {
+		now := metav1.Now()
+		rollout.LastTransitionTime = &now
+	}
+	ts := &hybridscalingv2.TrafficStat{
+		ObjectMeta: metav1.ObjectMeta{Name: "service-a-traffic", Namespace: "tenant-a"},
+		Spec:       hybridscalingv2.TrafficStatSpec{TargetRef: hybridscalingv2.TargetReference{Name: "service-a"}},
+		Status:     hybridscalingv2.TrafficStatStatus{Rollout: &rollout},
+	}
+	fakeClient := newTestClient(t, append(objects, svc, ts)...)
+	f := &rolloutFixture{
+		t:  t,
+		r:  &TrafficStatReconciler{Client: generationClient{fakeClient}, RevisionReadyTimeout: time.Minute},
+		ts: &hybridscalingv2.TrafficStat{},
+		profile: &hybridscalingv2.HybridScalingProfileSpec{RolloutPolicy: &hybridscalingv2.RolloutPolicy{
+			Cleanup: hybridscalingv2.CleanupKnativeGC, FailureBackoff: &metav1.Duration{Duration: 10 * time.Minute}}},
+	}
+	// The rollout is read back from the stored status, as after a restart of the controller
+	if err := f.r.Get(context.Background(), client.ObjectKeyFromObject(ts), f.ts); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// service returns the live Knative Service.
+func (f *rolloutFixture) service() *servingv1.Service {
+	svc := &servingv1.Service{}
+	if err := f.r.Get(context.Background(), client.ObjectKey{Namespace: "tenant-a", Name: "service-a"}, svc); err != nil {
+		f.t.Fatal(err)
+	}
+	return svc
+}
+
+// knativeCreated records the revision Knative created for the current generation of the Service, and the ready one.
+func (f *rolloutFixture) knativeCreated(created, ready string) {
+	svc := f.service()
+	svc.Status.ObservedGeneration = svc.Generation
+	svc.Status.LatestCreatedRevisionName = created
+	svc.Status.LatestReadyRevisionName = ready
+	if err := f.r.Update(context.Background(), svc); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// step runs one pass of the rollout and checks the phase it moved to.
+func (f *rolloutFixture) step(want hybridscalingv2.RolloutPhase) ctrl.Result {
+	f.t.Helper()
+	result, err := f.r.reconcileRollout(context.Background(), f.ts, f.service(), f.profile)
+	if err != nil {
+		f.t.Fatalf("reconcileRollout() error = %v", err)
+	}
+	if got := f.ts.Status.Rollout.Phase; got != want {
+		f.t.Fatalf("rollout phase = %s, want %s (reason %q)", got, want, f.ts.Status.Rollout.Reason)
+	}
+	return result
+}
+
+// backdate moves the last phase transition into the past.
+func (f *rolloutFixture) backdate(d time.Duration) {
+	past := metav1.NewTime(time.Now().Add(-d))
+	f.ts.Status.Rollout.LastTransitionTime = &past
+}
+
+func cpuResources(level string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(level)},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(level)},
+	}
+}
+
+func revisionWithReady(name string, ready corev1.ConditionStatus, actualReplicas int32) *servingv1.Revision {
+	rev := &servingv1.Revision{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant-a", Generation: 1,
+		Labels: map[string]string{serving.ServiceLabelKey: "service-a"}}}
+	rev.Status.ObservedGeneration = 1
+	rev.Status.ActualReplicas = &actualReplicas
+	rev.Status.Conditions = duckv1.Conditions{{Type: servingv1.RevisionConditionReady, Status: ready}}
+	return rev
+}
+
+func TestRolloutReplace(t *testing.T) {
+	f := newRolloutFixture(t, hybridscalingv2.RolloutStatus{Phase: hybridscalingv2.RolloutPhaseApplying},
+		revisionWithReady("service-a-00002", corev1.ConditionTrue, 4))
+
+	f.step(hybridscalingv2.RolloutPhaseWaitingForRevision)
+	svc := f.service()
+	if svc.Spec.Template.Annotations[autoscaling.TargetAnnotationKey] != "20" || f.ts.Status.Rollout.ServiceGeneration != 2 {
+		t.Fatalf("service template = %v at generation %d, want target 20 recorded at generation 2",
+			svc.Spec.Template.Annotations, f.ts.Status.Rollout.ServiceGeneration)
+	}
+
+	// Knative has not observed the patched generation yet
+	if result := f.step(hybridscalingv2.RolloutPhaseWaitingForRevision); result.RequeueAfter != revisionPollInterval {
+		t.Errorf("requeue after %s while waiting, want %s", result.RequeueAfter, revisionPollInterval)
+	}
+	f.knativeCreated("service-a-00002", "service-a-00002")
+	f.step(hybridscalingv2.RolloutPhaseDraining)
+	if f.ts.Status.Rollout.NewRevision != "service-a-00002" {
+		t.Errorf("new revision = %q, want service-a-00002", f.ts.Status.Rollout.NewRevision)
+	}
+
+	if result := f.step(hybridscalingv2.RolloutPhaseDraining); result.RequeueAfter <= 0 || result.RequeueAfter > drainDelay {
+		t.Errorf("requeue after %s before the drain delay, want at most %s", result.RequeueAfter, drainDelay)
+	}
+	f.backdate(drainDelay)
+	f.step(hybridscalingv2.RolloutPhaseDone)
+	if cond := meta.FindStatusCondition(f.ts.Status.Conditions, hybridscalingv2.ConditionOldRevisionCleaned); cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Errorf("OldRevisionCleaned = %+v, want True", cond)
+	}
+}
+
+func TestRolloutResumesAfterRestart(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		rollout hybridscalingv2.RolloutStatus
+		objects []client.Object
+		// knative is the latest created and ready revision reported by the Service, when it observed the last patch
+		knative [2]string
+		age     time.Duration
+		want    hybridscalingv2.RolloutPhase
+		check   func(f *rolloutFixture) string
+	}{{
+		name:    "applying",
+		rollout: hybridscalingv2.RolloutStatus{Phase: hybridscalingv2.RolloutPhaseApplying},
+		want:    hybridscalingv2.RolloutPhaseWaitingForRevision,
+		check: func(f *rolloutFixture) string {
+			if f.service().Spec.Template.Annotations[autoscaling.TargetAnnotationKey] != "20" {
+				return "the pair was not applied to the service"
+			}
+			return ""
+		},
+	}, {
+		name:    "waiting for revision",
+		rollout: hybridscalingv2.RolloutStatus{Phase: hybridscalingv2.RolloutPhaseWaitingForRevision, ServiceGeneration: 1},
+		objects: []client.Object{revisionWithReady("service-a-00002", corev1.ConditionTrue, 4)},
+		knative: [2]string{"service-a-00002", "service-a-00002"},
+		want:    hybridscalingv2.RolloutPhaseDraining,
+	}, {
+		name: "shifting",
+		rollout: hybridscalingv2.RolloutStatus{Phase: hybridscalingv2.RolloutPhaseShifting, ServiceGeneration: 1,
+			NewRevision: "service-a-00002", Strategy: hybridscalingv2.RolloutStrategyGradual, Steps: []int32{20, 50, 100}, TrafficPercent: 20},
+		objects: []client.Object{revisionWithReady("service-a-00002", corev1.ConditionTrue, 2)},
+		age:     defaultStepInterval,
+		want:    hybridscalingv2.RolloutPhaseShifting,
+		check: func(f *rolloutFixture) string {
+			traffic := f.service().Spec.Traffic
+			if f.ts.Status.Rollout.TrafficPercent != 50 || len(traffic) != 2 || *traffic[1].Percent != 50 {
+				return "the next step did not route 50% of the traffic to the new revision"
+			}
+			return ""
+		},
+	}, {
+		name:    "draining",
+		rollout: hybridscalingv2.RolloutStatus{Phase: hybridscalingv2.RolloutPhaseDraining, ServiceGeneration: 1, NewRevision: "service-a-00002"},
+		age:     drainDelay,
+		want:    hybridscalingv2.RolloutPhaseDone,
+	}, {
+		name: "rolling back",
+		rollout: hybridscalingv2.RolloutStatus{Phase: hybridscalingv2.RolloutPhaseRollingBack, ServiceGeneration: 1,
+			NewRevision: "service-a-00002", Reason: "RevisionReadyTimeout"},
+		knative: [2]string{"service-a-00003", "service-a-00003"},
+		want:    hybridscalingv2.RolloutPhaseFailed,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newRolloutFixture(t, tc.rollout, tc.objects...)
+			if tc.knative[0] != "" {
+				f.knativeCreated(tc.knative[0], tc.knative[1])
+			}
+			if tc.age > 0 {
+				f.backdate(tc.age)
+			}
+			f.step(tc.want)
+			if tc.check != nil {
+				if problem := tc.check(f); problem != "" {
+					t.Error(problem)
+				}
+			}
+		})
+	}
+}
+
+func TestRolloutTimeoutRollsBack(t *testing.T) {
+	pullFailure := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "service-a-00002-deployment-abc", Namespace: "tenant-a",
+			Labels: map[string]string{serving.RevisionLabelKey: "service-a-00002"}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "user-container",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "image not found"}}}}},
+	}
+	f := newRolloutFixture(t, hybridscalingv2.RolloutStatus{Phase: hybridscalingv2.RolloutPhaseApplying},
+		revisionWithReady("service-a-00002", corev1.ConditionUnknown, 0), pullFailure)
+	f.step(hybridscalingv2.RolloutPhaseWaitingForRevision)
+	f.knativeCreated("service-a-00002", "service-a-00001")
+	f.step(hybridscalingv2.RolloutPhaseWaitingForRevision)
+
+	// The revision misses the deadline of the reconciler
+	f.backdate(2 * time.Minute)
+	f.step(hybridscalingv2.RolloutPhaseRollingBack)
+	if f.ts.Status.Rollout.Reason != "ImagePullBackOff" {
+		t.Errorf("rollback reason = %q, want ImagePullBackOff from the pods of the new revision", f.ts.Status.Rollout.Reason)
+	}
+	if cond := meta.FindStatusCondition(f.ts.Status.Conditions, hybridscalingv2.ConditionRolloutFailed); cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Errorf("RolloutFailed = %+v, want True", cond)
+	}
+	svc := f.service()
+	if svc.Spec.Template.Annotations[autoscaling.TargetAnnotationKey] != "10" || svc.Spec.Template.Spec.Containers[0].Image != "registry.example.com/app:v1" {
+		t.Errorf("service template = %+v, want the known good target 10 and the owner's image", svc.Spec.Template)
+	}
+	if limit := svc.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU]; limit.Cmp(resource.MustParse("500m")) != 0 {
+		t.Errorf("cpu limit = %s, want the known good 500m", limit.String())
+	}
+	blocked := f.ts.Status.BlockedLevels
+	if len(blocked) != 1 || !optimizer.SameResources(blocked[0].Resources, cpuResources("1500m").Limits) {
+		t.Fatalf("blocked levels = %+v, want the 1500m level", blocked)
+	}
+	if backoff := time.Until(blocked[0].Until.Time); backoff < 9*time.Minute || backoff > 10*time.Minute {
+		t.Errorf("level blocked for %s, want the 10m failure backoff of the policy", backoff)
+	}
+
+	// The restored template is not ready yet, then Knative reports it Ready
+	f.step(hybridscalingv2.RolloutPhaseRollingBack)
+	f.knativeCreated("service-a-00003", "service-a-00003")
+	f.step(hybridscalingv2.RolloutPhaseFailed)
+}
+
+func TestRolloutCleanupDeadline(t *testing.T) {
+	// A PodDisruptionBudget that allows no disruption holds the cleanup of the previous revision
+	budget := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{serving.ServiceLabelKey: "service-a"}}},
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "service-a-00001-deployment-abc", Namespace: "tenant-a",
+		Labels: map[string]string{serving.RevisionLabelKey: "service-a-00001", serving.ServiceLabelKey: "service-a"}}}
+	f := newRolloutFixture(t, hybridscalingv2.RolloutStatus{Phase: hybridscalingv2.RolloutPhaseDraining, ServiceGeneration: 1, NewRevision: "service-a-00002"},
+		revisionWithReady("service-a-00001", corev1.ConditionTrue, 1), pod, budget)
+	f.profile.RolloutPolicy.Cleanup = hybridscalingv2.CleanupDrain
+
+	f.backdate(drainDelay)
+	f.step(hybridscalingv2.RolloutPhaseDraining)
+	f.backdate(drainDelay + 2*time.Minute)
+	f.step(hybridscalingv2.RolloutPhaseDone)
+	cond := meta.FindStatusCondition(f.ts.Status.Conditions, hybridscalingv2.ConditionOldRevisionCleaned)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "CleanupTimeout" {
+		t.Errorf("OldRevisionCleaned = %+v, want False with reason CleanupTimeout", cond)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/serving/pkg/apis/autoscaling"
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+// serviceMaxScale returns the max-scale the pair is applied with: the one the autoscaling policy derives,
+// else the one in effect on the service. 0 means max-scale does not bound the pods.
+func serviceMaxScale(managed, effective map[string]string) int32 {
+	value, ok := managed[autoscaling.MaxScaleAnnotationKey]
+	if !ok {
+		value = effective[autoscaling.MaxScaleAnnotationKey]
+	}
+	maxScale, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || maxScale < 0 {
+		return 0
+	}
+	return int32(maxScale)
+}
+
+// quotaPods returns how many more pods requesting the given resources the quotas have room for,
+// and the quota resource that bounds them. ok is false when no quota bounds the pods.
+// Scoped quotas are skipped, the pods of a Knative revision cannot be matched against their scopes up front.
+func quotaPods(quotas []corev1.ResourceQuota, requests corev1.ResourceList) (pods int64, limiting string, ok bool) {
+	for i := range quotas {
+		quota := &quotas[i]
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+		for name, hard := range quota.Status.Hard {
+			perPod, counted := quotaPerPod(name, requests)
+			if !counted || perPod.IsZero() {
+				continue
+			}
+			free := hard.DeepCopy()
+			free.Sub(quota.Status.Used[name])
+			room := int64(0)
+			if free.Sign() > 0 {
+				room = free.MilliValue() / perPod.MilliValue()
+			}
+			if !ok || room < pods {
+				pods, limiting, ok = room, quota.Name+"/"+string(name), true
+			}
+		}
+	}
+	return pods, limiting, ok
+}
+
+// quotaPerPod returns how much of the quota resource a pod of the pair uses.
+// The pair sets requests and limits to the same value, so both are read from the pod's requests.
+func quotaPerPod(name corev1.ResourceName, requests corev1.ResourceList) (resource.Quantity, bool) {
+	switch {
+	case name == corev1.ResourcePods || name == "count/pods":
+		return resource.MustParse("1"), true
+	case strings.HasPrefix(string(name), "requests."):
+		name = corev1.ResourceName(strings.TrimPrefix(string(name), "requests."))
+	case strings.HasPrefix(string(name), "limits."):
+		name = corev1.ResourceName(strings.TrimPrefix(string(name), "limits."))
+	case name != corev1.ResourceCPU && name != corev1.ResourceMemory && name != corev1.ResourceEphemeralStorage:
+		return resource.Quantity{}, false
+	}
+	amount, found := requests[name]
+	return amount, found
+}
+
+// limitScale checks the predicted pods against max-scale and the namespace quotas.
+// running pods of the same revision are already counted in the quota usage and keep their room.
+func limitScale(predicted, maxScale int32, quotaRoom int64, quotaLimiting string, quotaBound bool, running int32) *hybridscalingv2.ScaleStatus {
+	scale := &hybridscalingv2.ScaleStatus{Predicted: predicted, Applied: predicted}
+	if maxScale > 0 {
+		scale.MaxScale = &maxScale
+		if scale.Applied > maxScale {
+			scale.Applied = maxScale
+			scale.LimitedBy = hybridscalingv2.ScaleLimitMaxScale
+		}
+	}
+	if quotaBound {
+		room := quotaRoom + int64(running)
+		if room > math.MaxInt32 {
+			room = math.MaxInt32
+		}
+		quotaPods := int32(room)
+		scale.QuotaPods = &quotaPods
+		scale.Limiting = quotaLimiting
+		if scale.Applied > quotaPods {
+			scale.Applied = quotaPods
+			scale.LimitedBy = hybridscalingv2.ScaleLimitResourceQuota
+		}
+	}
+	return scale
+}
+
+// validateScale lists the ResourceQuotas of the namespace, limits the predicted pods of the pair with them and max-scale,
+// and sets the ScaleWithinLimits condition. A pod is sized as in the capacity check, sidecars included.
+func (r *TrafficStatReconciler) validateScale(ctx context.Context, ts *hybridscalingv2.TrafficStat, svc *servingv1.Service, resources corev1.ResourceList,
+	predicted, maxScale, running int32) *hybridscalingv2.ScaleStatus {
+	var room int64
+	var limiting string
+	var bound bool
+	quotas := &corev1.ResourceQuotaList{}
+	err := r.List(ctx, quotas, client.InNamespace(svc.Namespace))
+	if err == nil {
+		room, limiting, bound = quotaPods(quotas.Items, podSize(svc, r.runningSidecarRequests(ctx, svc), resources))
+	}
+	scale := limitScale(predicted, maxScale, room, limiting, bound, running)
+
+	switch scale.LimitedBy {
+	case hybridscalingv2.ScaleLimitMaxScale:
+		setCondition(ts, hybridscalingv2.ConditionScaleWithinLimits, metav1.ConditionFalse, "MaxScaleExceeded",
+			fmt.Sprintf("%d pods are predicted, max-scale of the service allows %d", scale.Predicted, scale.Applied))
+	case hybridscalingv2.ScaleLimitResourceQuota:
+		setCondition(ts, hybridscalingv2.ConditionScaleWithinLimits, metav1.ConditionFalse, "QuotaExceeded",
+			fmt.Sprintf("%d pods are predicted, %s has room for %d", scale.Predicted, scale.Limiting, scale.Applied))
+	default:
+		if err != nil {
+			setCondition(ts, hybridscalingv2.ConditionScaleWithinLimits, metav1.ConditionUnknown, "QuotaUnknown", err.Error())
+			break
+		}
+		setCondition(ts, hybridscalingv2.ConditionScaleWithinLimits, metav1.ConditionTrue, "WithinLimits",
+			fmt.Sprintf("%d pods are within max-scale and the namespace quotas", scale.Predicted))
+	}
+	return scale
+}
+
+// runningSidecarRequests returns what a pod of the service requests besides its serving container,
+// read from the pods of the running revision in the cache.
+func (r *TrafficStatReconciler) runningSidecarRequests(ctx context.Context, svc *servingv1.Service) corev1.ResourceList {
+	pods := &corev1.PodList{}
+	if svc.Status.LatestReadyRevisionName != "" {
+		if err := r.List(ctx, pods, client.InNamespace(svc.Namespace),
+			client.MatchingLabels{serving.RevisionLabelKey: svc.Status.LatestReadyRevisionName}); err != nil {
+			loggerSD.Error(err, "unable to list pods of the running revision, sidecar requests are taken from the template")
+		}
+	}
+	return sidecarRequests(svc, pods.Items)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestQuotaPods(t *testing.T) {
+	quota := func(name string, hard, used corev1.ResourceList) corev1.ResourceQuota {
+		return corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.ResourceQuotaStatus{Hard: hard, Used: used},
+		}
+	}
+	scoped := quota("best-effort", corev1.ResourceList{corev1.ResourcePods: resource.MustParse("0")}, nil)
+	scoped.Spec.Scopes = []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort}
+	quotas := []corev1.ResourceQuota{
+		quota("compute", corev1.ResourceList{
+			corev1.ResourceRequestsCPU:       resource.MustParse("10"),
+			corev1.ResourceLimitsMemory:      resource.MustParse("8Gi"),
+			corev1.ResourcePods:              resource.MustParse("20"),
+			corev1.ResourceServicesNodePorts: resource.MustParse("0"),
+		}, corev1.ResourceList{
+			corev1.ResourceRequestsCPU:  resource.MustParse("4500m"),
+			corev1.ResourceLimitsMemory: resource.MustParse("2Gi"),
+			corev1.ResourcePods:         resource.MustParse("3"),
+		}),
+		scoped,
+	}
+	requests := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m"), corev1.ResourceMemory: resource.MustParse("512Mi")}
+
+	// 5500m of cpu left is room for 3 pods of 1500m, memory and pod count leave more
+	pods, limiting, ok := quotaPods(quotas, requests)
+	if !ok || pods != 3 || limiting != "compute/requests.cpu" {
+		t.Errorf("quotaPods() = %d, %q, %v, want 3, compute/requests.cpu, true", pods, limiting, ok)
+	}
+	if _, _, ok := quotaPods([]corev1.ResourceQuota{scoped}, requests); ok {
+		t.Error("quotaPods() bounded the pods with a scoped quota")
+	}
+}
+
+func TestLimitScale(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		predicted, maxScale int32
+		quotaRoom           int64
+		quotaBound          bool
+		running             int32
+		wantApplied         int32
+		wantLimitedBy       hybridscalingv2.ScaleLimit
+	}{
+		{name: "unbounded", predicted: 24, wantApplied: 24},
+		{name: "max-scale", predicted: 24, maxScale: 10, wantApplied: 10, wantLimitedBy: hybridscalingv2.ScaleLimitMaxScale},
+		{name: "quota", predicted: 24, maxScale: 30, quotaRoom: 5, quotaBound: true, wantApplied: 5, wantLimitedBy: hybridscalingv2.ScaleLimitResourceQuota},
+		// The 12 running pods of the revision are already counted in the quota usage
+		{name: "running pods", predicted: 15, quotaRoom: 5, quotaBound: true, running: 12, wantApplied: 15},
+	} {
+		scale := limitScale(tc.predicted, tc.maxScale, tc.quotaRoom, "compute/pods", tc.quotaBound, tc.running)
+		if scale.Predicted != tc.predicted || scale.Applied != tc.wantApplied || scale.LimitedBy != tc.wantLimitedBy {
+			t.Errorf("%s: limitScale() = %+v, want %d applied, limited by %q", tc.name, scale, tc.wantApplied, tc.wantLimitedBy)
+		}
+	}
+}
+
+func TestValidateScaleCountsSidecars(t *testing.T) {
+	cpu := func(quantity string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(quantity)}}
+	}
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "tenant-a"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("3")},
+			Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("0")},
+		},
+	}
+	// The queue-proxy of the running revision requests 100m next to the serving container
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "service-a-00001-deployment-abc", Namespace: "tenant-a",
+			Labels: map[string]string{serving.RevisionLabelKey: "service-a-00001"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: cpu("500m")}, {Name: "queue-proxy", Resources: cpu("100m")}}},
+	}
+	svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"}}
+	svc.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Resources: cpu("500m")}}
+	svc.Status.LatestReadyRevisionName = "service-a-00001"
+	r := &TrafficStatReconciler{Client: newTestClient(t, quota, pod)}
+
+	// 3 cpu is room for 6 pods of 500m, but only for 5 with their queue-proxy
+	scale := r.validateScale(context.Background(), &hybridscalingv2.TrafficStat{}, svc,
+		corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}, 6, 0, 0)
+	if scale.Applied != 5 || scale.LimitedBy != hybridscalingv2.ScaleLimitResourceQuota {
+		t.Errorf("validateScale() = %d pods limited by %q, want 5 limited by %q", scale.Applied, scale.LimitedBy, hybridscalingv2.ScaleLimitResourceQuota)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+	"github.com/mipearlska/knative_hybrid_scaling/pkg/optimizer"
+)
+
+// stabilizationPolicy returns the StabilizationPolicy of the TrafficStat, else of its profile.
+func stabilizationPolicy(ts *hybridscalingv2.TrafficStat, profile *hybridscalingv2.HybridScalingProfileSpec) *hybridscalingv2.StabilizationPolicy {
+	if ts.Spec.Stabilization != nil {
+		return ts.Spec.Stabilization
+	}
+	return profile.Stabilization
+}
+
+// currentDecision returns the decision of the pair the service runs, given the limits of its serving container,
+// nil when that pair is not in the profile.
+func currentDecision(decisions []optimizer.Decision, limits corev1.ResourceList, concurrency string) *optimizer.Decision {
+	for i := range decisions {
+		if resourcesApplied(limits, decisions[i].Resources) && concurrencyMatches(concurrency, decisions[i].Entry.OptimalConcurrency) {
+			return &decisions[i]
+		}
+	}
+	return nil
+}
+
+// resourcesApplied reports whether the container limits hold every resource of the pair with the same quantity.
+// Quantities are compared, so 1500m and 1.5 or 1Gi and 1024Mi are the same.
+func resourcesApplied(limits, resources corev1.ResourceList) bool {
+	for name, quantity := range resources {
+		limit, ok := limits[name]
+		if !ok || limit.Cmp(quantity) != 0 {
+			return false
+		}
+	}
+	return len(resources) > 0
+}
+
+// samePair reports whether two decisions use the same resource-concurrency pair.
+func samePair(a, b *optimizer.Decision) bool {
+	return optimizer.SameResources(a.Resources, b.Resources) && a.Entry.OptimalConcurrency == b.Entry.OptimalConcurrency
+}
+
+// describeLevel returns the resources of the decision's entry as shown in the status:
+// the level of the intensive resource, or the whole resource list.
+func describeLevel(decision *optimizer.Decision) string {
+	if len(decision.Entry.ResourceList) == 0 {
+		return decision.Entry.Resources.String()
+	}
+	return optimizer.FormatResources(decision.Resources)
+}
+
+// describeTotal returns the total resources of the decision as shown in the status:
+// the total of the intensive resource, or of every resource of the entry.
+func describeTotal(profile *hybridscalingv2.HybridScalingProfileSpec, decision *optimizer.Decision) string {
+	if len(decision.Entry.ResourceList) == 0 {
+		total := decision.Total[profile.IntensiveResourceType]
+		return total.String()
+	}
+	return optimizer.FormatResources(decision.Total)
+}
+
+// holdSwitch returns the reason and message why the switch from the current pair to the best one is held back,
+// or an empty reason when the switch may go ahead.
+func holdSwitch(policy *hybridscalingv2.StabilizationPolicy, lastSwitch *metav1.Time, best, current *optimizer.Decision, now time.Time) (string, string) {
+	if policy == nil || current == nil || samePair(best, current) {
+		return "", ""
+	}
+	if policy.Cooldown != nil && lastSwitch != nil {
+		if remaining := lastSwitch.Add(policy.Cooldown.Duration).Sub(now); remaining > 0 {
+			return "Cooldown", fmt.Sprintf("switch to %s with concurrency %d held for %s, last switch at %s",
+				describeLevel(best), best.Entry.OptimalConcurrency, remaining.Round(time.Second), lastSwitch.UTC().Format(time.RFC3339))
+		}
+	}
+	if policy.MinImprovementPercentage != nil {
+		improvement := 0.
+		if current.Cost > 0 {
+			improvement = (current.Cost - best.Cost) / current.Cost * 100
+		}
+		if improvement < float64(*policy.MinImprovementPercentage) {
+			return "InsufficientImprovement", fmt.Sprintf("switch to %s with concurrency %d saves %.1f%% of the current pair's cost, below the %d%% minimum",
+				describeLevel(best), best.Entry.OptimalConcurrency, improvement, *policy.MinImprovementPercentage)
+		}
+	}
+	return "", ""
+}
+
+// minScaleWithinTolerance reports whether the current min-scale annotation value is close enough to the predicted pods to be kept.
+func minScaleWithinTolerance(policy *hybridscalingv2.StabilizationPolicy, minScale string, pods int32) bool {
+	current, err := strconv.ParseFloat(minScale, 64)
+	if err != nil {
+		return false
+	}
+	tolerance := 0.
+	if policy != nil && policy.MinScaleTolerancePercentage != nil {
+		tolerance = float64(*policy.MinScaleTolerancePercentage) / 100
+	}
+	return math.Abs(float64(pods)-current) <= current*tolerance
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+	"github.com/mipearlska/knative_hybrid_scaling/pkg/optimizer"
+)
+
+func testDecision(level string, concurrency, pods int32, cost float64) optimizer.Decision {
+	return optimizer.Decision{
+		Entry:     hybridscalingv2.ProfileEntry{Resources: resource.MustParse(level), OptimalConcurrency: concurrency},
+		Resources: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(level)},
+		Pods:      pods,
+		Cost:      cost,
+	}
+}
+
+func TestHoldSwitch(t *testing.T) {
+	now := time.Now()
+	best := testDecision("4000m", 50, 3, 12000)
+	current := testDecision("3500m", 40, 4, 14000)
+	recent := metav1.NewTime(now.Add(-time.Minute))
+	old := metav1.NewTime(now.Add(-time.Hour))
+	improvement := func(p int32) *int32 { return &p }
+
+	for _, tc := range []struct {
+		name       string
+		policy     *hybridscalingv2.StabilizationPolicy
+		lastSwitch *metav1.Time
+		current    *optimizer.Decision
+		want       string
+	}{
+		{name: "no policy", current: &current},
+		{name: "pair not in profile", policy: &hybridscalingv2.StabilizationPolicy{MinImprovementPercentage: improvement(50)}},
+		{name: "cooldown", policy: &hybridscalingv2.StabilizationPolicy{Cooldown: &metav1.Duration{Duration: 10 * time.Minute}},
+			lastSwitch: &recent, current: &current, want: "Cooldown"},
+		{name: "cooldown over", policy: &hybridscalingv2.StabilizationPolicy{Cooldown: &metav1.Duration{Duration: 10 * time.Minute}},
+			lastSwitch: &old, current: &current},
+		// 12 instead of 14 cores saves 14.3%
+		{name: "insufficient improvement", policy: &hybridscalingv2.StabilizationPolicy{MinImprovementPercentage: improvement(20)},
+			current: &current, want: "InsufficientImprovement"},
+		{name: "sufficient improvement", policy: &hybridscalingv2.StabilizationPolicy{MinImprovementPercentage: improvement(10)},
+			current: &current},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, message := holdSwitch(tc.policy, tc.lastSwitch, &best, tc.current, now); got != tc.want {
+				t.Errorf("holdSwitch() = %q (%s), want %q", got, message, tc.want)
+			}
+		})
+	}
+}
+
+func TestMinScaleWithinTolerance(t *testing.T) {
+	tolerance := int32(25)
+	policy := &hybridscalingv2.StabilizationPolicy{MinScaleTolerancePercentage: &tolerance}
+	for _, tc := range []struct {
+		policy   *hybridscalingv2.StabilizationPolicy
+		minScale string
+		pods     int32
+		want     bool
+	}{
+		{policy: nil, minScale: "8", pods: 8, want: true},
+		{policy: nil, minScale: "8", pods: 9, want: false},
+		{policy: policy, minScale: "8", pods: 10, want: true},
+		{policy: policy, minScale: "8", pods: 11, want: false},
+		{policy: policy, minScale: "", pods: 1, want: false},
+	} {
+		if got := minScaleWithinTolerance(tc.policy, tc.minScale, tc.pods); got != tc.want {
+			t.Errorf("minScaleWithinTolerance(%q, %d) = %v, want %v", tc.minScale, tc.pods, got, tc.want)
+		}
+	}
+}
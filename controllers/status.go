@@ -0,0 +1,98 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+	"github.com/mipearlska/knative_hybrid_scaling/pkg/optimizer"
+)
+
+// statusCandidates is how many ranked pairs are listed in the decision status.
+const statusCandidates = 5
+
+// setCondition adds or updates a condition of the TrafficStat status.
+// LastTransitionTime only moves when the condition status changes.
+func setCondition(ts *hybridscalingv2.TrafficStat, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&ts.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: ts.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setFetchCondition sets a *Found condition from the result of a Get.
+func setFetchCondition(ts *hybridscalingv2.TrafficStat, conditionType string, err error, foundMessage string) {
+	switch {
+	case err == nil:
+		setCondition(ts, conditionType, metav1.ConditionTrue, "Found", foundMessage)
+	case apierrors.IsNotFound(err):
+		setCondition(ts, conditionType, metav1.ConditionFalse, "NotFound", err.Error())
+	default:
+		setCondition(ts, conditionType, metav1.ConditionUnknown, "FetchFailed", err.Error())
+	}
+}
+
+// setDecision records the chosen pair in the status.
+// DecisionTime and the applied revision are kept as long as the pair itself does not change.
+func setDecision(ts *hybridscalingv2.TrafficStat, decision hybridscalingv2.DecisionStatus) {
+	if current := ts.Status.Decision; current != nil &&
+		current.ResourceLevel == decision.ResourceLevel && current.Concurrency == decision.Concurrency {
+		decision.DecisionTime = current.DecisionTime
+		decision.AppliedRevision = current.AppliedRevision
+		decision.AppliedTime = current.AppliedTime
+	} else {
+		now := metav1.Now()
+		decision.DecisionTime = &now
+	}
+	ts.Status.Decision = &decision
+}
+
+// setAppliedRevision records the revision that runs the chosen pair.
+func setAppliedRevision(ts *hybridscalingv2.TrafficStat, revision string) {
+	if ts.Status.Decision == nil || ts.Status.Decision.AppliedRevision == revision {
+		return
+	}
+	now := metav1.Now()
+	ts.Status.Decision.AppliedRevision = revision
+	ts.Status.Decision.AppliedTime = &now
+}
+
+// candidateStatuses returns the best ranked decisions as listed in the decision status.
+func candidateStatuses(profile *hybridscalingv2.HybridScalingProfileSpec, decisions []optimizer.Decision) []hybridscalingv2.CandidateStatus {
+	if len(decisions) > statusCandidates {
+		decisions = decisions[:statusCandidates]
+	}
+	candidates := make([]hybridscalingv2.CandidateStatus, 0, len(decisions))
+	for i := range decisions {
+		candidates = append(candidates, hybridscalingv2.CandidateStatus{
+			ResourceLevel:          describeLevel(&decisions[i]),
+			Concurrency:            strconv.Itoa(int(decisions[i].Entry.OptimalConcurrency)),
+			ExpectedPods:           decisions[i].Pods,
+			ExpectedTotalResources: describeTotal(profile, &decisions[i]),
+			Cost:                   strconv.FormatFloat(decisions[i].Cost, 'g', 6, 64),
+		})
+	}
+	return candidates
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestSetDecision(t *testing.T) {
+	decided := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	applied := metav1.NewTime(time.Now().Add(-30 * time.Minute).Truncate(time.Second))
+	running := &hybridscalingv2.DecisionStatus{
+		ResourceLevel: "cpu=500m", Concurrency: "10", ExpectedPods: 4,
+		DecisionTime: &decided, AppliedRevision: "service-a-00001", AppliedTime: &applied,
+	}
+
+	for _, tc := range []struct {
+		name     string
+		current  *hybridscalingv2.DecisionStatus
+		decision hybridscalingv2.DecisionStatus
+		// wantKept is whether DecisionTime and the applied revision of the current decision are kept
+		wantKept bool
+	}{
+		{name: "first decision", decision: hybridscalingv2.DecisionStatus{ResourceLevel: "cpu=500m", Concurrency: "10"}},
+		// Only the pod count moves, the revision running the pair still applies it
+		{name: "same pair", current: running, decision: hybridscalingv2.DecisionStatus{ResourceLevel: "cpu=500m", Concurrency: "10", ExpectedPods: 6}, wantKept: true},
+		{name: "other level", current: running, decision: hybridscalingv2.DecisionStatus{ResourceLevel: "cpu=1500m", Concurrency: "10"}},
+		{name: "other concurrency", current: running, decision: hybridscalingv2.DecisionStatus{ResourceLevel: "cpu=500m", Concurrency: "20"}},
+	} {
+		ts := &hybridscalingv2.TrafficStat{}
+		if tc.current != nil {
+			ts.Status.Decision = tc.current.DeepCopy()
+		}
+		setDecision(ts, tc.decision)
+
+		got := ts.Status.Decision
+		if got.ExpectedPods != tc.decision.ExpectedPods || got.ResourceLevel != tc.decision.ResourceLevel || got.Concurrency != tc.decision.Concurrency {
+			t.Errorf("%s: decision = %+v, want %+v", tc.name, got, tc.decision)
+		}
+		if got.DecisionTime == nil {
+			t.Fatalf("%s: DecisionTime not set", tc.name)
+		}
+		if tc.wantKept {
+			if !got.DecisionTime.Equal(&decided) || got.AppliedRevision != "service-a-00001" || got.AppliedTime == nil || !got.AppliedTime.Equal(&applied) {
+				t.Errorf("%s: decision = %+v, want DecisionTime %v and the applied revision kept", tc.name, got, decided)
+			}
+			continue
+		}
+		if got.DecisionTime.Equal(&decided) || got.AppliedRevision != "" || got.AppliedTime != nil {
+			t.Errorf("%s: decision = %+v, want a new DecisionTime and no applied revision", tc.name, got)
+		}
+	}
+}
+
+func TestSetAppliedRevision(t *testing.T) {
+	applied := metav1.NewTime(time.Now().Add(-30 * time.Minute).Truncate(time.Second))
+
+	for _, tc := range []struct {
+		name         string
+		decision     *hybridscalingv2.DecisionStatus
+		revision     string
+		wantRevision string
+		wantMoved    bool
+	}{
+		{name: "no decision", revision: "service-a-00002"},
+		{name: "first revision", decision: &hybridscalingv2.DecisionStatus{}, revision: "service-a-00001", wantRevision: "service-a-00001", wantMoved: true},
+		{name: "same revision", decision: &hybridscalingv2.DecisionStatus{AppliedRevision: "service-a-00001", AppliedTime: &applied},
+			revision: "service-a-00001", wantRevision: "service-a-00001"},
+		{name: "new revision", decision: &hybridscalingv2.DecisionStatus{AppliedRevision: "service-a-00001", AppliedTime: &applied},
+			revision: "service-a-00002", wantRevision: "service-a-00002", wantMoved: true},
+	} {
+		ts := &hybridscalingv2.TrafficStat{}
+		ts.Status.Decision = tc.decision.DeepCopy()
+		setAppliedRevision(ts, tc.revision)
+
+		if tc.decision == nil {
+			if ts.Status.Decision != nil {
+				t.Errorf("%s: decision = %+v, want none", tc.name, ts.Status.Decision)
+			}
+			continue
+		}
+		got := ts.Status.Decision
+		if got.AppliedRevision != tc.wantRevision || got.AppliedTime == nil {
+			t.Errorf("%s: applied revision = %q at %v, want %q", tc.name, got.AppliedRevision, got.AppliedTime, tc.wantRevision)
+			continue
+		}
+		if moved := !got.AppliedTime.Equal(&applied); moved != tc.wantMoved {
+			t.Errorf("%s: AppliedTime moved = %v, want %v", tc.name, moved, tc.wantMoved)
+		}
+	}
+}
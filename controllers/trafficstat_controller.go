@@ -18,46 +18,87 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
 	"strconv"
-	"strings"
-
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 
-	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-
+	"knative.dev/serving/pkg/apis/autoscaling"
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
 	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
-	servingv1client "knative.dev/serving/pkg/client/clientset/versioned/typed/serving/v1"
+	autoscalerconfig "knative.dev/serving/pkg/autoscaler/config"
 
-	hybridscalingv1 "github.com/mipearlska/knative_hybrid_scaling/api/v1"
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+	"github.com/mipearlska/knative_hybrid_scaling/pkg/optimizer"
 )
 
 var (
 	loggerSD = ctrl.Log.WithName("ControllerLOG")
 )
 
-// TrafficStatReconciler reconciles a TrafficStat object
+// TrafficStatReconciler reconciles a TrafficStat object.
+// Knative Serving types are registered in the manager's scheme, so the embedded
+// client is used for Services and Revisions as well as for core resources.
 type TrafficStatReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// MaxConcurrentReconciles is the number of TrafficStats (and so services) reconciled in parallel.
+	MaxConcurrentReconciles int
+
+	// RevisionReadyTimeout is how long a new revision may take to become Ready before the rollout fails.
+	RevisionReadyTimeout time.Duration
+
+	// KnativeServingNamespace is the namespace of Knative Serving's config-autoscaler ConfigMap.
+	KnativeServingNamespace string
+
+	// Recorder records the revision cleanup actions as Events on the TrafficStat.
+	Recorder record.EventRecorder
+
+	// WatchNamespaces are the namespaces the manager's cache holds, all of them when empty.
+	WatchNamespaces []string
+
+	// CheckCapacity checks the pairs against the free capacity of the nodes. The cache then holds the nodes and the pods
+	// of the whole cluster, so it is off when the controller watches a subset of namespaces.
+	CheckCapacity bool
+
+	// autoscalerConfig caches config-autoscaler only, it is created by SetupWithManager.
+	autoscalerConfig cache.Cache
 }
 
 //+kubebuilder:rbac:groups=hybridscaling.knativescaling.dcn.ssu.ac.kr,resources=trafficstats,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=hybridscaling.knativescaling.dcn.ssu.ac.kr,resources=trafficstats/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=hybridscaling.knativescaling.dcn.ssu.ac.kr,resources=trafficstats/finalizers,verbs=update
+//+kubebuilder:rbac:groups=hybridscaling.knativescaling.dcn.ssu.ac.kr,resources=hybridscalingprofiles,verbs=get;list;watch
 
 //+kubebuilder:rbac:groups=serving.knative.dev,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=serving.knative.dev,resources=revisions,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=autoscaling.internal.knative.dev,resources=podautoscalers,verbs=get;list;watch;patch
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -68,363 +109,479 @@ type TrafficStatReconciler struct {
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
-func (r *TrafficStatReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *TrafficStatReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, reterr error) {
 	log := log.FromContext(ctx)
 	log.Info("reconciling foo custom resource")
 
 	// Get the TrafficStat resource that trigger the reconciliation request
-	var TrafficStatCRD = hybridscalingv1.TrafficStat{}
+	var TrafficStatCRD = hybridscalingv2.TrafficStat{}
 	if err := r.Get(ctx, req.NamespacedName, &TrafficStatCRD); err != nil {
 		loggerSD.Error(err, "unable to fetch client")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
-	} else {
-		loggerSD.Info("Fetched TrafficStatCRD, target service is: ", "TARGET_SERVICE", TrafficStatCRD.Spec.ServiceName)
 	}
-	// Store Wanted/Target ServiceName from CRD in TargetServiceName variable
-	CRDTargetServiceName := TrafficStatCRD.Spec.ServiceName
-
-	// Initialize Knative Serving Go Client
-	// Ref:https://stackoverflow.com/questions/66199455/list-service-in-go
-	// This Testbed's MasterNode kubeconfig path = "/root/.kube/config"
 
-	config, err := clientcmd.BuildConfigFromFlags("", "/root/.kube/config")
-	if err != nil {
-		loggerSD.Error(err, "unable to BuildConfigFromFlags using clientcmd")
+	//// A deleted TrafficStat gives its Service back before it goes, the finalizer holds it until then
+	if !TrafficStatCRD.DeletionTimestamp.IsZero() {
+		return r.reconcileDelete(ctx, &TrafficStatCRD)
+	}
+	if controllerutil.AddFinalizer(&TrafficStatCRD, TrafficStatFinalizer) {
+		if err := r.Update(ctx, &TrafficStatCRD); err != nil {
+			loggerSD.Error(err, "unable to add finalizer to TrafficStat")
+			return ctrl.Result{}, err
+		}
 	}
 
-	serving, err := servingv1client.NewForConfig(config)
+	//// Suspended TrafficStats only report their decisions, an override pins the pair until it expires
+	Mode, OverrideRemaining := scalingMode(&TrafficStatCRD, time.Now())
+
+	// Status changes made during this reconcile are written once, when it returns
+	StatusSnapshot := TrafficStatCRD.Status.DeepCopy()
+	TrafficStatCRD.Status.Mode = Mode
+	defer func() {
+		// Predictive scaling resumes when the override expires, whatever this reconcile waits for
+		if reterr == nil {
+			result = requeueAtExpiry(result, OverrideRemaining)
+		}
+		TrafficStatCRD.Status.ObservedGeneration = TrafficStatCRD.Generation
+		if equality.Semantic.DeepEqual(StatusSnapshot, &TrafficStatCRD.Status) {
+			return
+		}
+		if err := r.Status().Update(ctx, &TrafficStatCRD); err != nil {
+			loggerSD.Error(err, "unable to update TrafficStat status")
+			if reterr == nil {
+				reterr = err
+			}
+		}
+	}()
+
+	// Store Wanted/Target ServiceName and Namespace from CRD
+	// Target Service and its hybrid profile ConfigMap live in TrafficStat's namespace unless spec.targetRef says otherwise
+	CRDTargetServiceName := targetServiceName(&TrafficStatCRD)
+	TargetNamespace := targetNamespace(&TrafficStatCRD)
+	loggerSD.Info("Fetched TrafficStatCRD, target service is: ", "TARGET_SERVICE", CRDTargetServiceName, "TARGET_NAMESPACE", TargetNamespace)
+	//// A target outside the watched namespaces cannot be read from the cache, the TrafficStat waits for spec.targetRef to change
+	if !r.watchesNamespace(TargetNamespace) {
+		loggerSD.Info("Target namespace is not watched", "TARGET_NAMESPACE", TargetNamespace)
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionServiceFound, metav1.ConditionFalse, "NamespaceNotWatched",
+			fmt.Sprintf("namespace %s of the target is not watched by the controller (--watch-namespaces)", TargetNamespace))
+		return ctrl.Result{}, nil
+	}
+	//// When spec.targetRef moves to another Service, the previous one is given back as on deletion before the new one is taken over
+	PreviousService, err := r.resetForTarget(ctx, &TrafficStatCRD)
 	if err != nil {
-		loggerSD.Error(err, "unable to create Knative Serving Go Client")
+		loggerSD.Error(err, "unable to restore the previous Knative Service of the TrafficStat", "ORIGINAL_SERVICE", TrafficStatCRD.Status.Original.Service)
+		return ctrl.Result{}, err
 	}
-
-	//**Get Service's Concurrency-Resources ConfigMap (CR ConfigMap) with name == TrafficStatCRD.spec.servicename
-	TargetConfigMap := &corev1.ConfigMap{}
-	FetchConfigMapObjectKey := client.ObjectKey{
-		Namespace: "default",
-		Name:      "hybrid-" + CRDTargetServiceName,
+	if PreviousService != "" {
+		loggerSD.Info("Target changed, previous service given back", "SERVICE_NAME", CRDTargetServiceName, "ORIGINAL_SERVICE", PreviousService)
+		r.event(&TrafficStatCRD, corev1.EventTypeNormal, "TargetChanged", "spec.targetRef moved from Knative Service %s", PreviousService)
 	}
 
-	if err := r.Get(ctx, FetchConfigMapObjectKey, TargetConfigMap); err != nil {
-		loggerSD.Error(err, "unable to fetch ConfigMap corresponding to CRDTargetService")
+	//**Get Service's Concurrency-Resources profile (CR profile): HybridScalingProfile, or the legacy "hybrid-<service>" ConfigMap
+	TargetProfile, ProfileSource, err := r.getProfile(ctx, &TrafficStatCRD, TargetNamespace)
+	//**Without a profile, the NamespaceDefault fallback reads the namespace default HybridScalingProfile instead
+	Fallback := fallbackPolicy(&TrafficStatCRD)
+	if apierrors.IsNotFound(err) && Fallback.Mode == hybridscalingv2.FallbackNamespaceDefault {
+		DefaultProfile, DefaultProfileSource, DefaultErr := r.namespaceDefaultProfile(ctx, Fallback, TargetNamespace)
+		if DefaultErr == nil {
+			TargetProfile, ProfileSource, err = DefaultProfile, DefaultProfileSource, nil
+		} else if !apierrors.IsNotFound(DefaultErr) {
+			err = DefaultErr
+		}
+	}
+	ProfileMissing := apierrors.IsNotFound(err)
+	ProfileInvalid := errors.Is(err, errInvalidProfile)
+	if ProfileInvalid {
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionProfileFound, metav1.ConditionFalse, "InvalidProfile", err.Error())
+	} else {
+		setFetchCondition(&TrafficStatCRD, hybridscalingv2.ConditionProfileFound, err, ProfileSource+" found")
+	}
+	if err != nil {
+		loggerSD.Error(err, "unable to fetch hybrid profile corresponding to CRDTargetService")
+		if !ProfileMissing && !ProfileInvalid {
+			return ctrl.Result{}, err
+		}
+		TargetProfile = &hybridscalingv2.HybridScalingProfileSpec{}
 	} else {
-		loggerSD.Info("Fetch ConfigMap sucessful:", "CONFIG_MAP-NAME", TargetConfigMap.Name)
+		loggerSD.Info("Fetch hybrid profile sucessful:", "PROFILE", ProfileSource)
 	}
 
 	//**Get Service with name == TrafficStatCRD.spec.servicename
-	TargetService, err := serving.Services("default").Get(ctx, CRDTargetServiceName, metav1.GetOptions{})
+	TargetService := &servingv1.Service{}
+	FetchServiceObjectKey := client.ObjectKey{
+		Namespace: TargetNamespace,
+		Name:      CRDTargetServiceName,
+	}
+	err = r.Get(ctx, FetchServiceObjectKey, TargetService)
+	setFetchCondition(&TrafficStatCRD, hybridscalingv2.ConditionServiceFound, err, "Knative Service "+FetchServiceObjectKey.Name+" found")
 	if err != nil {
 		loggerSD.Info("TargetService name from CRD is:", "SERVICE_NAME", CRDTargetServiceName)
 		loggerSD.Error(err, "TargetService from CRD is not available in cluster")
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		//// Nothing can be decided without the Service, the Service watch reconciles the TrafficStat once it is created
+		return ctrl.Result{RequeueAfter: notFoundBackoff(&TrafficStatCRD, hybridscalingv2.ConditionServiceFound, time.Now())}, nil
 	} else {
 		loggerSD.Info("TargetService name from CRD is:", "SERVICE_NAME", CRDTargetServiceName)
 		loggerSD.Info("Found TargetService in cluster:", "SERVICE_NAME", TargetService.Name)
 	}
 
-	TargetService_Type := TargetConfigMap.Data["resources-intensive-type"]
-	TargetService_RequiredResources := TargetConfigMap.Data["required-resources"]
-	TargetService_Current_Revision := TargetService.Status.LatestReadyRevisionName
-	TargetService_Current_Pair_Concurrency := TargetService.Spec.Template.ObjectMeta.Annotations["autoscaling.knative.dev/target"]
-	TargetService_Current_Pair_Resources_Limit := TargetService.Spec.Template.Spec.Containers[0].Resources.Limits
-	TargetService_Current_Pair_Resources := ""
+	//// A rollout in progress is driven to completion (or failure) before a new pair is considered
+	if Rollout := TrafficStatCRD.Status.Rollout; Rollout != nil && Rollout.Phase != hybridscalingv2.RolloutPhaseDone && Rollout.Phase != hybridscalingv2.RolloutPhaseFailed {
+		return r.reconcileRollout(ctx, &TrafficStatCRD, TargetService, TargetProfile)
+	}
 
-	if TargetService_Type == "cpu" {
-		temp1 := strings.Split(fmt.Sprintf("%v", TargetService_Current_Pair_Resources_Limit["cpu"]), "=")
-		temp2 := strings.Split(temp1[0], " ")
-		temp3 := temp2[0][2:]
-		if len(temp3) == 1 {
-			temp3 = temp3 + "000"
-		}
-		TargetService_Current_Pair_Resources = temp3 + "m"
+	//// Without a profile the fallback policy decides what is done with the Service, unless an override pins the pair
+	if ProfileMissing && Mode != hybridscalingv2.ScalingModeOverride {
+		return r.reconcileFallback(ctx, &TrafficStatCRD, TargetService)
 	}
-	if TargetService_Type == "memory" {
-		temp1 := strings.Split(fmt.Sprintf("%v", TargetService_Current_Pair_Resources_Limit["memory"]), "=")
-		temp2 := strings.Split(temp1[0], " ")
-		temp3, err := strconv.Atoi(temp2[0][2:])
-		if err != nil {
-			loggerSD.Error(err, err.Error())
-		}
-		TargetService_Current_Pair_Resources = strconv.Itoa(temp3/1048576) + "Mi"
+	//// An invalid profile is reported, the profile watch reconciles the TrafficStat once it is fixed
+	if ProfileInvalid {
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "InvalidProfile", "the hybrid profile cannot be used")
+		return ctrl.Result{}, nil
+	}
+
+	TargetService_Type := TargetProfile.IntensiveResourceType
+	TargetService_RequiredResources := TargetProfile.FixedResources
+	TargetService_Current_Pair_Concurrency := TargetService.Spec.Template.ObjectMeta.Annotations[autoscaling.TargetAnnotationKey]
+	TargetService_Current_Metric := TargetService.Spec.Template.ObjectMeta.Annotations[autoscaling.MetricAnnotationKey]
+	TargetService_Current_MinScale := TargetService.Spec.Template.ObjectMeta.Annotations[autoscaling.MinScaleAnnotationKey]
+	// Current resources of the serving container, the pair is read from its limits
+	var TargetService_Current_Pair_Resources corev1.ResourceList
+	if Container := servingContainer(&TargetService.Spec.Template.Spec); Container != nil {
+		TargetService_Current_Pair_Resources = Container.Resources.Limits
 	}
 	loggerSD.Info("TargetService Type is", "TYPE", TargetService_Type)
-	loggerSD.Info("TargetService Required Resources is", "Required_RESOURCE", TargetService_RequiredResources)
+	loggerSD.Info("TargetService Required Resources is", "Required_RESOURCE", fmt.Sprintf("%v", TargetService_RequiredResources))
 	loggerSD.Info("TargetService Current Pair-Concurrency is", "Current_Pair_CONCURRENCY", TargetService_Current_Pair_Concurrency)
-	loggerSD.Info("TargetService Current Pair-Resources is", "Current_Pair_RESOURCES", TargetService_Current_Pair_Resources)
+	loggerSD.Info("TargetService Current Pair-Resources is", "Current_Pair_RESOURCES", optimizer.FormatResources(TargetService_Current_Pair_Resources))
 
 	//**Scaling Logic:
-	// If wanted service and CR ConfigMap (get from above) avaialble - NOT null:
-	// Calculate optimal concurrency and resources request configuration based on TrafficStatCRD.spec.ScalingInputTraffic and Service's CR ConfigMap
-	// CR setting with minimum TotalResourceUsage = chosen CR
-	minimumCR_TotalResourcesUsage := float64(100000000)
-	ScalingInputTrafficFloat, err := strconv.ParseFloat(TrafficStatCRD.Spec.ScalingInputTraffic, 64)
+	// If wanted service and CR profile (get from above) avaialble - NOT null:
+	// Calculate optimal concurrency and resources request configuration based on TrafficStatCRD.spec.traffic and Service's CR profile
+	// The CRD schema only accepts numbers as traffic value, its sign is checked here
+	ScalingInputTrafficFloat := TrafficStatCRD.Spec.Traffic.Value.AsApproximateFloat64()
+	if ScalingInputTrafficFloat < 0 {
+		TrafficErr := fmt.Errorf("spec.traffic.value %s must not be negative", TrafficStatCRD.Spec.Traffic.Value.String())
+		loggerSD.Error(TrafficErr, TrafficErr.Error())
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "InvalidTraffic", TrafficErr.Error())
+		return ctrl.Result{}, nil
+	}
+	//**KPA loads each pod with target utilization x target concurrency, pods are counted the same way
+	Policy := autoscalingPolicy(&TrafficStatCRD, TargetProfile)
+	TargetUtilization, TargetUtilizationSource, err := r.targetUtilization(ctx, TargetService, TargetProfile, TrafficStatCRD.Spec.Traffic.Unit,
+		Policy.Manages(hybridscalingv2.ManagedTargetUtilization))
 	if err != nil {
-		loggerSD.Error(err, err.Error())
+		loggerSD.Error(err, "unable to resolve target utilization")
+		return ctrl.Result{}, err
 	}
-	var chosen_resourceLevel string
-	var chosen_concurrency string
-	var chosen_numberofpod string
+	loggerSD.Info("TargetService Target Utilization is", "TARGET_UTILIZATION", TargetUtilization, "SOURCE", TargetUtilizationSource)
 
-	for resourceLevel, concurrency := range TargetConfigMap.Data {
-		if resourceLevel != "resources-intensive-type" && resourceLevel != "required-resources" {
-			ConcurrencyFloat, Cerr := strconv.ParseFloat(concurrency, 64)
-			if Cerr != nil {
-				loggerSD.Error(Cerr, Cerr.Error())
-			}
-			resourceLevelFloat, Rerr := strconv.ParseFloat(resourceLevel, 64)
-			if Rerr != nil {
-				loggerSD.Error(Rerr, Rerr.Error())
-			}
-			ConcurrencyFloat = ConcurrencyFloat * 0.7 //KNative Default Target Concurrency Percentage = 70%
-			NumberOfPod := math.Ceil(ScalingInputTrafficFloat / ConcurrencyFloat)
-			ThisCR_TotalResourcesUsage := NumberOfPod * resourceLevelFloat
-			loggerSD.Info("CR Pair", "CR_PAIR", resourceLevel+concurrency)
-			loggerSD.Info("This CR Pair Expected NumberOfPod", "EX_NUMBER_OF_PODS", fmt.Sprintf("%v", NumberOfPod))
-			loggerSD.Info("This CR Pair Expected Total Resources Usage", "EX_TOTAL_RESOURCES", fmt.Sprintf("%v", ThisCR_TotalResourcesUsage))
-			if ThisCR_TotalResourcesUsage < minimumCR_TotalResourcesUsage {
-				minimumCR_TotalResourcesUsage = ThisCR_TotalResourcesUsage
-				if TargetService_Type == "cpu" {
-					chosen_resourceLevel = resourceLevel + "m"
-				} else if TargetService_Type == "memory" {
-					chosen_resourceLevel = resourceLevel + "Mi"
-				}
-				chosen_concurrency = concurrency
-				chosen_numberofpod = strconv.FormatFloat(NumberOfPod, 'g', 1, 64)
-			}
+	//**Rank the profile entries with the optimizer strategy of the TrafficStat, or of the profile
+	OptimizerName := optimizerName(&TrafficStatCRD, TargetProfile)
+	Optimizer, err := optimizer.Get(OptimizerName)
+	if err != nil {
+		loggerSD.Error(err, err.Error())
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "UnknownOptimizer", err.Error())
+		return ctrl.Result{}, nil
+	}
+	//**An active override pins the pair, the ranked pairs are still computed and reported
+	var Override *optimizer.Decision
+	if Mode == hybridscalingv2.ScalingModeOverride {
+		OverrideDecision, err := overrideDecision(TargetProfile, TrafficStatCRD.Spec.Override)
+		if err != nil {
+			loggerSD.Error(err, err.Error())
+			setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "InvalidOverride", err.Error())
+			return ctrl.Result{}, nil
 		}
+		Override = &OverrideDecision
+	}
+	//**Nodes and pods of the cluster, read for the NodeShape objective and the capacity check
+	Constraints := optimizer.Constraints{TargetUtilization: TargetUtilization, TieBreak: tieBreak(&TrafficStatCRD, TargetProfile)}
+	Cluster, err := r.readCluster(ctx)
+	if err != nil {
+		loggerSD.Error(err, "unable to read nodes and pods, pairs are not checked against the cluster capacity")
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionFitsCapacity, metav1.ConditionUnknown, "CapacityUnknown", err.Error())
+	} else if Cluster == nil {
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionFitsCapacity, metav1.ConditionUnknown, "CapacityNotChecked",
+			"the controller watches a subset of namespaces, pairs are not checked against the cluster capacity")
+	} else {
+		Constraints.NodeShape = nodeShape(Cluster.nodes)
+	}
+	//**Levels whose rollout failed are left out until their backoff expires
+	AvailableProfile, NextUnblock := withoutBlockedLevels(&TrafficStatCRD, TargetProfile, time.Now())
+	Decisions, err := Optimizer.Rank(AvailableProfile, optimizer.Prediction{
+		Traffic: ScalingInputTrafficFloat,
+		Unit:    TrafficStatCRD.Spec.Traffic.Unit,
+	}, Constraints)
+	if err != nil {
+		loggerSD.Error(err, err.Error())
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "OptimizerFailed", err.Error())
+		return ctrl.Result{}, nil
 	}
+	for _, Decision := range Decisions {
+		loggerSD.Info("CR Pair", "CR_PAIR", describeLevel(&Decision)+"/"+strconv.Itoa(int(Decision.Entry.OptimalConcurrency)))
+		loggerSD.Info("This CR Pair Expected NumberOfPod", "EX_NUMBER_OF_PODS", Decision.Pods)
+		loggerSD.Info("This CR Pair Expected Total Resources Usage", "EX_TOTAL_RESOURCES", optimizer.FormatResources(Decision.Total), "COST", Decision.Cost)
+	}
+	if len(Decisions) == 0 && len(TargetProfile.Entries) > 0 && Override == nil {
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "NoCandidate",
+			"every resource level of the hybrid profile is blocked after a failed rollout")
+		return ctrl.Result{RequeueAfter: NextUnblock}, nil
+	}
+	if len(Decisions) == 0 && Override == nil {
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "NoCandidate", "hybrid profile has no resource-concurrency pair")
+		return ctrl.Result{}, nil
+	}
+	//**Pairs whose pods do not fit on the nodes are discarded, the best ranked pair that fits is kept
+	if len(Decisions) > 0 {
+		Decisions = feasibleDecisions(&TrafficStatCRD, TargetService, Cluster, Decisions,
+			currentDecision(Decisions, TargetService_Current_Pair_Resources, TargetService_Current_Pair_Concurrency))
+	}
+	if len(Decisions) == 0 && Override == nil {
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "InsufficientCapacity", "no resource-concurrency pair fits on the nodes")
+		return ctrl.Result{}, nil
+	}
+	// The best ranked candidate is the chosen CR, unless the stabilization policy keeps the current one.
+	// An override is applied as it is, stabilization does not hold it back.
+	var Chosen optimizer.Decision
+	var Stabilization *hybridscalingv2.StabilizationPolicy
+	if Override != nil {
+		Chosen = *Override
+		OptimizerName = "override until " + TrafficStatCRD.Spec.Override.Until.UTC().Format(time.RFC3339)
+	} else {
+		Chosen = Decisions[0]
+		Stabilization = stabilizationPolicy(&TrafficStatCRD, TargetProfile)
+		Current := currentDecision(Decisions, TargetService_Current_Pair_Resources, TargetService_Current_Pair_Concurrency)
+		if HoldReason, HoldMessage := holdSwitch(Stabilization, TrafficStatCRD.Status.LastSwitchTime, &Chosen, Current, time.Now()); HoldReason != "" {
+			loggerSD.Info("Keep current CR pair", "REASON", HoldReason, "MESSAGE", HoldMessage)
+			setCondition(&TrafficStatCRD, hybridscalingv2.ConditionSwitchHeld, metav1.ConditionTrue, HoldReason, HoldMessage)
+			Chosen = *Current
+		} else if Stabilization != nil {
+			setCondition(&TrafficStatCRD, hybridscalingv2.ConditionSwitchHeld, metav1.ConditionFalse, "BestPair", "the best ranked pair is applied")
+		}
+	}
+	chosen_resourceLevel := describeLevel(&Chosen)
+	chosen_resources := Chosen.Resources
+	chosen_concurrency := Chosen.Entry.OptimalConcurrency
+	chosen_expectedpods := Chosen.Pods
+	chosen_totalresources := describeTotal(TargetProfile, &Chosen)
+
+	setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionTrue, "Computed",
+		fmt.Sprintf("%s: %s with concurrency %d, %d pods at %v%% target utilization (%s)", OptimizerName, chosen_resourceLevel,
+			chosen_concurrency, chosen_expectedpods, TargetUtilization*100, TargetUtilizationSource))
+	setDecision(&TrafficStatCRD, hybridscalingv2.DecisionStatus{
+		ResourceLevel:          chosen_resourceLevel,
+		Concurrency:            strconv.Itoa(int(chosen_concurrency)),
+		ExpectedPods:           chosen_expectedpods,
+		ExpectedTotalResources: chosen_totalresources,
+		Candidates:             candidateStatuses(TargetProfile, Decisions),
+	})
+
+	//// Annotations the autoscaling policy manages (utilization, max-scale, burst capacity, panic window, scale-down delay) follow the decision
+	chosen_annotations := managedAnnotations(Policy, TargetUtilization, ScalingInputTrafficFloat, chosen_expectedpods, TrafficStatCRD.Spec.Traffic.Unit)
 
 	//// Only Update Service to a new Revision/Configuration if the new calculated autoscaling settings (res-con) is DIFFERENT with the current one
-	if chosen_concurrency == TargetService_Current_Pair_Concurrency && chosen_resourceLevel == TargetService_Current_Pair_Resources {
+	//// Resources are compared as quantities, so 1500m and 1.5 or 1Gi and 1024Mi are the same level
+	SamePair := concurrencyMatches(TargetService_Current_Pair_Concurrency, chosen_concurrency) &&
+		resourcesApplied(TargetService_Current_Pair_Resources, chosen_resources)
+	VerticalChange := !SamePair || !metricMatches(TargetService_Current_Metric, TrafficStatCRD.Spec.Traffic.Unit)
+
+	//// Horizontal change: the running revision keeps its pair, only pod count related annotations move.
+	//// They are patched on the revision's PodAutoscaler, which KPA reads them from, so no new Revision is created.
+	var CurrentPodAutoscaler *autoscalingv1alpha1.PodAutoscaler
+	if !VerticalChange {
+		CurrentPodAutoscaler, err = r.readyPodAutoscaler(ctx, TargetService)
+		if err != nil {
+			loggerSD.Error(err, "unable to fetch PodAutoscaler of the ready revision")
+			return ctrl.Result{}, err
+		}
+	}
+	// The annotations in effect are those of the PodAutoscaler once it was patched, else those of the template
+	Effective_Annotations := TargetService.Spec.Template.Annotations
+	if CurrentPodAutoscaler != nil {
+		Effective_Annotations = CurrentPodAutoscaler.Annotations
+		TargetService_Current_MinScale = CurrentPodAutoscaler.Annotations[autoscaling.MinScaleAnnotationKey]
+	}
+
+	//// The pod count is checked against max-scale and the namespace quotas before it is written into initial-scale and min-scale.
+	//// Pods of the running revision are already counted in the quota usage when it keeps serving the pair.
+	var Running_Pods int32
+	if CurrentPodAutoscaler != nil && CurrentPodAutoscaler.Status.ActualScale != nil {
+		Running_Pods = *CurrentPodAutoscaler.Status.ActualScale
+	}
+	Scale := r.validateScale(ctx, &TrafficStatCRD, TargetService, chosen_resources, chosen_expectedpods,
+		serviceMaxScale(chosen_annotations, Effective_Annotations), Running_Pods)
+	TrafficStatCRD.Status.Decision.Scale = Scale
+	if Scale.Applied < 1 && Scale.Predicted > 0 {
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionDecisionComputed, metav1.ConditionFalse, "QuotaExceeded",
+			fmt.Sprintf("%s has no room for a pod of %s", Scale.Limiting, chosen_resourceLevel))
+		return ctrl.Result{}, nil
+	}
+	chosen_numberofpod := strconv.Itoa(int(Scale.Applied))
+	loggerSD.Info("Chosen CR pair NumberOfPod", "PREDICTED", Scale.Predicted, "APPLIED", Scale.Applied, "LIMITED_BY", Scale.LimitedBy)
+
+	//// A suspended TrafficStat reports its decision, nothing is applied to the Service
+	if Mode == hybridscalingv2.ScalingModeSuspended {
+		loggerSD.Info("TrafficStat suspended, decision not applied", "RESOURCE", chosen_resourceLevel, "CONCURRENCY", chosen_concurrency, "NUMBEROFPOD", chosen_numberofpod)
+		return ctrl.Result{}, nil
+	}
+
+	//// Within the min-scale tolerance band the predicted pod count does not warrant a change on its own
+	if !VerticalChange && minScaleWithinTolerance(Stabilization, TargetService_Current_MinScale, Scale.Applied) &&
+		annotationsMatch(Effective_Annotations, chosen_annotations) {
 		loggerSD.Info("Keep current service res-con autoscaling setting")
+		setAppliedRevision(&TrafficStatCRD, TargetService.Status.LatestReadyRevisionName)
+	} else if !VerticalChange && CurrentPodAutoscaler == nil {
+		//// The latest revision is not ready yet, a pod count change waits for its PodAutoscaler rather than minting a new revision
+		loggerSD.Info("Keep current service res-con autoscaling setting, waiting for the ready revision to scale it",
+			"LATEST_CREATED", TargetService.Status.LatestCreatedRevisionName, "LATEST_READY", TargetService.Status.LatestReadyRevisionName)
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionRevisionReady, metav1.ConditionUnknown, "Waiting",
+			fmt.Sprintf("min-scale waits for revision %s to become Ready", TargetService.Status.LatestCreatedRevisionName))
+		return ctrl.Result{RequeueAfter: conditionBackoff(&TrafficStatCRD, hybridscalingv2.ConditionRevisionReady, metav1.ConditionUnknown,
+			revisionPollInterval, maxReadyRevisionBackoff, time.Now())}, nil
+	} else if !VerticalChange {
+		ScaleAnnotations := map[string]string{autoscaling.MinScaleAnnotationKey: chosen_numberofpod}
+		for k, v := range chosen_annotations {
+			ScaleAnnotations[k] = v
+		}
+		//// The Service settings from before the controller first changes them are kept, they are restored when the TrafficStat is deleted
+		if err := r.recordOriginal(ctx, &TrafficStatCRD, TargetService, sortedKeys(ScaleAnnotations), nil); err != nil {
+			loggerSD.Error(err, "unable to record the original settings of the service", "SERVICE_NAME", TargetService.Name)
+			return ctrl.Result{}, err
+		}
+		if err := r.patchPodAutoscaler(ctx, CurrentPodAutoscaler, ScaleAnnotations); err != nil {
+			loggerSD.Error(err, "unable to patch PodAutoscaler", "PA_NAME", CurrentPodAutoscaler.Name)
+			return ctrl.Result{}, err
+		}
+		loggerSD.Info("Keep current service res-con autoscaling setting, scaled PodAutoscaler", "PA_NAME", CurrentPodAutoscaler.Name, "NUMBEROFPOD", chosen_numberofpod)
+		setCondition(&TrafficStatCRD, hybridscalingv2.ConditionRevisionReady, metav1.ConditionTrue, "Ready",
+			"revision "+TargetService.Status.LatestReadyRevisionName+" is Ready")
+		setAppliedRevision(&TrafficStatCRD, TargetService.Status.LatestReadyRevisionName)
 	} else {
 
 		loggerSD.Info("Chosen CR settings for Hybrid scaling is", "RESOURCE", chosen_resourceLevel)
 		loggerSD.Info("Chosen CR settings for Hybrid scaling is", "CONCURRENCY", chosen_concurrency)
 		loggerSD.Info("Chosen CR settings for Hybrid scaling is", "NUMBEROFPOD", chosen_numberofpod)
 
-		//// Define Configuration Yaml Object
-		//// When a new Service is created, Knative assign for that Service an annotation "serving.knative.dev/creator" = The user that created the service.
-		//// In this experiment, the root user at k8s master node created --> serving.knative.dev/creator = kubernetes-admin
-		//// To be able to update a new Configuration for a Service, Knative-K8s requires 2 things:
-		//// - serving.knative.dev/creator must be the same one find on "kubectl describe ksvc <service-name>"'s Annotation
-		////  --> Add this to new Configuration's metadata/annotations
-		//// - New Configuration ResourceVersion must be the same with the current one.
-		////  ---> GetResourceVersion() of current service, set it to the new one.
-		////  ---> This line below do that "configuration.SetResourceVersion(service.GetResourceVersion())"
-		var NewServiceConfiguration *servingv1.Service
-
-		if TargetService_Type == "cpu" {
-
-			NewServiceConfiguration = &servingv1.Service{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      CRDTargetServiceName,
-					Namespace: "default",
-					Labels: map[string]string{
-						"app": CRDTargetServiceName,
-					},
-					Annotations: map[string]string{
-						"serving.knative.dev/creator":      "kubernetes-admin",
-						"serving.knative.dev/lastModifier": "kubernetes-admin",
-					},
-				},
-				Spec: servingv1.ServiceSpec{
-					ConfigurationSpec: servingv1.ConfigurationSpec{
-						Template: servingv1.RevisionTemplateSpec{
-							ObjectMeta: metav1.ObjectMeta{
-								Labels: map[string]string{
-									"app": CRDTargetServiceName,
-								},
-								Annotations: map[string]string{
-									"autoscaling.knative.dev/target":        chosen_concurrency,
-									"autoscaling.knative.dev/initial-scale": chosen_numberofpod,
-									"autoscaling.knative.dev/min-scale":     chosen_numberofpod,
-								},
-							},
-							Spec: servingv1.RevisionSpec{
-								PodSpec: corev1.PodSpec{
-									Containers: []corev1.Container{
-										{
-											Name:  CRDTargetServiceName,
-											Image: "vudinhdai2505/test-app:v5",
-											Resources: corev1.ResourceRequirements{
-												Requests: map[corev1.ResourceName]resource.Quantity{
-													"memory": resource.MustParse(TargetService_RequiredResources),
-												},
-												Limits: map[corev1.ResourceName]resource.Quantity{
-													"cpu":    resource.MustParse(chosen_resourceLevel),
-													"memory": resource.MustParse(TargetService_RequiredResources),
-												},
-											},
-											Ports: []corev1.ContainerPort{
-												{
-													ContainerPort: 5000,
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			}
-
-		} else if TargetService_Type == "memory" {
-
-			NewServiceConfiguration = &servingv1.Service{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      CRDTargetServiceName,
-					Namespace: "default",
-					Labels: map[string]string{
-						"app": CRDTargetServiceName,
-					},
-					Annotations: map[string]string{
-						"serving.knative.dev/creator":      "kubernetes-admin",
-						"serving.knative.dev/lastModifier": "kubernetes-admin",
-					},
-				},
-				Spec: servingv1.ServiceSpec{
-					ConfigurationSpec: servingv1.ConfigurationSpec{
-						Template: servingv1.RevisionTemplateSpec{
-							ObjectMeta: metav1.ObjectMeta{
-								Labels: map[string]string{
-									"app": CRDTargetServiceName,
-								},
-								Annotations: map[string]string{
-									"autoscaling.knative.dev/target":        chosen_concurrency,
-									"autoscaling.knative.dev/initial-scale": chosen_numberofpod,
-									"autoscaling.knative.dev/min-scale":     chosen_numberofpod,
-								},
-							},
-							Spec: servingv1.RevisionSpec{
-								PodSpec: corev1.PodSpec{
-									Containers: []corev1.Container{
-										{
-											Name:  CRDTargetServiceName,
-											Image: "vudinhdai2505/test-app:v5",
-											Resources: corev1.ResourceRequirements{
-												Requests: map[corev1.ResourceName]resource.Quantity{
-													"cpu": resource.MustParse(TargetService_RequiredResources),
-												},
-												Limits: map[corev1.ResourceName]resource.Quantity{
-													"cpu":    resource.MustParse(TargetService_RequiredResources),
-													"memory": resource.MustParse(chosen_resourceLevel),
-												},
-											},
-											Ports: []corev1.ContainerPort{
-												{
-													ContainerPort: 5000,
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			}
+		//// Roll out the chosen pair: only the autoscaling annotations and container resources of the live Service are patched.
+		//// Everything else the Service owner set (image, env, probes, volumes, other annotations) is kept,
+		//// and Knative records the change as a new Revision.
+		//// The container gets every resource of the chosen entry: its resource list, or the chosen level next to the profile's fixed resources.
+		if !SamePair {
+			now := metav1.Now()
+			TrafficStatCRD.Status.LastSwitchTime = &now
 		}
+		if err := r.startRollout(ctx, &TrafficStatCRD, TargetService, hybridscalingv2.HybridPair{
+			Resources:    chosen_resources,
+			Concurrency:  strconv.Itoa(int(chosen_concurrency)),
+			NumberOfPods: Scale.Applied,
+			Unit:         TrafficStatCRD.Spec.Traffic.Unit,
+			Annotations:  chosen_annotations,
+		}, rolloutPolicy(&TrafficStatCRD, TargetProfile)); err != nil {
+			return ctrl.Result{}, err
+		}
+		StatusSnapshot = TrafficStatCRD.Status.DeepCopy()
+		return r.reconcileRollout(ctx, &TrafficStatCRD, TargetService, TargetProfile)
+	}
 
-		loggerSD.Info("Creating new Configuration for service ", "SERVICE_NAME", CRDTargetServiceName)
-		loggerSD.Info("with chosen settings ", "CHOSEN_RESOURCE_LEVEL", chosen_resourceLevel)
-		loggerSD.Info("with chosen settings ", "CHOSEN_RESOURCE_LEVEL", chosen_concurrency)
-
-		//// Set ResourceVersion of new Configuration to the current Service's ResourceVersion (Required for Update)
-		NewServiceConfiguration.SetResourceVersion(TargetService.GetResourceVersion())
+	return ctrl.Result{}, nil
 
-		//// Call KnativeServingClient to create new Service Revision by updating current service with new Configuration
-		NewServiceRevision, err := serving.Services("default").Update(ctx, NewServiceConfiguration, metav1.UpdateOptions{})
+}
 
-		// New Revision Number = current + 1 (from service-00009 to service-00010) (Below are string processing to get the new Revision ID/Number)
-		tempstring := strings.Split(TargetService_Current_Revision, "-")
-		tempint, _ := strconv.Atoi(tempstring[len(tempstring)-1])
-		rev_number := strconv.Itoa(tempint + 1)
-		New_Revision_Number := CRDTargetServiceName + "-" + strings.Repeat("0", 5-len(rev_number)) + rev_number
-		if err != nil {
-			loggerSD.Error(err, err.Error())
-		} else {
-			loggerSD.Info("New Service Revision Created", "SERVICE", NewServiceRevision.Name)
-			loggerSD.Info("New Service Revision Number", "REV_NUMBER", New_Revision_Number)
-
-			// Watch New Revision,
-			// Wait until new Revision ready (Pod Running)
-			// Delete old Revision and the corresponding pods (to handle previous Revision long Terminating pods time, which can hold a lot of worker node resources)
-
-			// While Loop to wait until New Revision Pod Ready to serve
-			for {
-				time.Sleep(1 * time.Second)
-				BeforeDeleteRevisionPodList := &corev1.PodList{}
-				if err := r.List(ctx, BeforeDeleteRevisionPodList); err != nil {
-					loggerSD.Error(err, err.Error())
-					break
-				}
-				count := 0
-				newPodDeploy := false
-				for _, pod := range BeforeDeleteRevisionPodList.Items {
-					if strings.HasPrefix(pod.Name, New_Revision_Number) && pod.Status.Phase == "Running" {
-						newPodDeploy = true // New Pod Not Ready, keep previous Revision alive
-						count += 1
-					} else if strings.HasPrefix(pod.Name, New_Revision_Number) && pod.Status.Phase != "Running" {
-						newPodDeploy = false
-					}
-				}
-				if count == 0 || !newPodDeploy {
-					loggerSD.Info("New Revision Pod NOT READY", "REV_NUMBER", New_Revision_Number)
-				} else { // only when New Revision Pod Ready, process to Delete Previous Revision Pods step
-					loggerSD.Info("New Revision Pod Running")
-					break
-				}
-			}
+// targetServiceName returns the name of the Knative Service scaled by the TrafficStat.
+func targetServiceName(ts *hybridscalingv2.TrafficStat) string {
+	return ts.Spec.TargetRef.Name
+}
 
-			loggerSD.Info("Wait")
-			time.Sleep(5 * time.Second)
-
-			// New Revision Pods are READY now, Delete old Revision and old Revision pods
-			// Check if old Revision Pods are still Terminating. If YES delete old Revision, Then Delete pod
-			ReadyDeleteRevisionPodList := &corev1.PodList{}
-			if err := r.List(ctx, ReadyDeleteRevisionPodList); err != nil {
-				loggerSD.Error(err, err.Error())
-			} else {
-				count := 0 // count to ensure Delete Revision is only called one time in the PodList loop (when count = 1)
-				for _, pod := range ReadyDeleteRevisionPodList.Items {
-					if strings.HasPrefix(pod.Name, TargetService_Current_Revision) {
-						targetpod := &corev1.Pod{
-							ObjectMeta: metav1.ObjectMeta{
-								Namespace: "default",
-								Name:      pod.Name,
-							},
-						}
-						count += 1
-						if count == 1 {
-							loggerSD.Info("Ask to delete Revision", "REVISION_NAME", TargetService_Current_Revision)
-
-							err := serving.Revisions("default").Delete(context.Background(), TargetService_Current_Revision, metav1.DeleteOptions{})
-							if err != nil {
-								loggerSD.Error(err, err.Error())
-							} else {
-								loggerSD.Info("Delete Revision ", "REVISION_NAME", TargetService_Current_Revision)
-							}
-							time.Sleep(2 * time.Second)
-						}
-
-						if err := r.Delete(ctx, targetpod, client.GracePeriodSeconds(0)); err != nil {
-							loggerSD.Error(err, err.Error())
-						} else {
-							loggerSD.Info("Delete pod ", "POD_NAME", pod.Name)
-						}
-					}
-				}
-			}
+// watchesNamespace reports whether the manager's cache holds the namespace.
+func (r *TrafficStatReconciler) watchesNamespace(namespace string) bool {
+	if len(r.WatchNamespaces) == 0 {
+		return true
+	}
+	for _, watched := range r.WatchNamespaces {
+		if watched == namespace {
+			return true
 		}
 	}
+	return false
+}
 
-	return ctrl.Result{}, nil
+// targetNamespace returns the namespace of the Knative Service and its hybrid profile.
+func targetNamespace(ts *hybridscalingv2.TrafficStat) string {
+	if ts.Spec.TargetRef.Namespace != "" {
+		return ts.Spec.TargetRef.Namespace
+	}
+	return ts.Namespace
+}
+
+// optimizerName returns the optimizer strategy of the TrafficStat, else of its profile.
+// An empty name selects optimizer.DefaultStrategy.
+func optimizerName(ts *hybridscalingv2.TrafficStat, profile *hybridscalingv2.HybridScalingProfileSpec) string {
+	if ts.Spec.Optimizer != "" {
+		return ts.Spec.Optimizer
+	}
+	if profile.Optimizer != "" {
+		return profile.Optimizer
+	}
+	return optimizer.DefaultStrategy
+}
 
+// tieBreak returns the tie-break rules of the TrafficStat, else of its profile.
+func tieBreak(ts *hybridscalingv2.TrafficStat, profile *hybridscalingv2.HybridScalingProfileSpec) []hybridscalingv2.TieBreaker {
+	if len(ts.Spec.TieBreak) > 0 {
+		return ts.Spec.TieBreak
+	}
+	return profile.TieBreak
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *TrafficStatReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// config-autoscaler lives in Knative's namespace, which the manager's cache may not cover in namespaced mode
+	autoscalerConfig, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme:    mgr.GetScheme(),
+		Mapper:    mgr.GetRESTMapper(),
+		Namespace: r.knativeServingNamespace(),
+		SelectorsByObject: cache.SelectorsByObject{
+			&corev1.ConfigMap{}: {Field: fields.OneTermEqualSelector("metadata.name", autoscalerconfig.ConfigName)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := mgr.Add(autoscalerConfig); err != nil {
+		return err
+	}
+	r.autoscalerConfig = autoscalerConfig
+
+	// TrafficStats are found back from the service and profile they reference
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &hybridscalingv2.TrafficStat{}, targetServiceIndex, indexTargetService); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &hybridscalingv2.TrafficStat{}, profileIndex, indexProfile); err != nil {
+		return err
+	}
+	// The capacity check reads the pods of each node from the cache
+	if r.CheckCapacity {
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podNodeIndex, indexPodNode); err != nil {
+			return err
+		}
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&hybridscalingv1.TrafficStat{}).
+		// Rollout steps are driven by RequeueAfter, status updates made by the controller itself do not need to trigger a reconcile
+		For(&hybridscalingv2.TrafficStat{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, deletionPredicate))).
+		Watches(source.NewKindWithCache(&corev1.ConfigMap{}, autoscalerConfig),
+			handler.EnqueueRequestsFromMapFunc(r.requestsForAutoscalerConfig)).
+		Watches(&source.Kind{Type: &hybridscalingv2.HybridScalingProfile{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForProfile), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForLegacyProfile), builder.WithPredicates(legacyProfilePredicate)).
+		Watches(&source.Kind{Type: &servingv1.Service{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForService), builder.WithPredicates(serviceChangedPredicate)).
+		Watches(&source.Kind{Type: &servingv1.Revision{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForRevision), builder.WithPredicates(revisionReadinessPredicate)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
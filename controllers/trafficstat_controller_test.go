@@ -0,0 +1,74 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestReconcileTargetNamespace(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		targetNamespace string
+		watched         []string
+		wantStatus      metav1.ConditionStatus
+		wantReason      string
+	}{
+		// The service is looked up in the TrafficStat's namespace
+		{name: "own namespace", wantStatus: metav1.ConditionTrue, wantReason: "Found"},
+		{name: "other namespace", targetNamespace: "tenant-b", wantStatus: metav1.ConditionTrue, wantReason: "Found"},
+		{name: "watched", targetNamespace: "tenant-b", watched: []string{"tenant-a", "tenant-b"}, wantStatus: metav1.ConditionTrue, wantReason: "Found"},
+		{name: "not watched", targetNamespace: "tenant-b", watched: []string{"tenant-a"}, wantStatus: metav1.ConditionFalse, wantReason: "NamespaceNotWatched"},
+	} {
+		namespace := tc.targetNamespace
+		if namespace == "" {
+			namespace = "tenant-a"
+		}
+		// Only the service of the expected namespace exists
+		svc := &servingv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service-a", Namespace: namespace}}
+		ts := &hybridscalingv2.TrafficStat{
+			ObjectMeta: metav1.ObjectMeta{Name: "service-a-traffic", Namespace: "tenant-a"},
+			Spec:       hybridscalingv2.TrafficStatSpec{TargetRef: hybridscalingv2.TargetReference{Name: "service-a", Namespace: tc.targetNamespace}},
+		}
+		r := &TrafficStatReconciler{Client: newTestClient(t, svc, ts), WatchNamespaces: tc.watched}
+
+		result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(ts)})
+		if err != nil {
+			t.Fatalf("%s: Reconcile() error = %v", tc.name, err)
+		}
+		if err := r.Get(context.Background(), client.ObjectKeyFromObject(ts), ts); err != nil {
+			t.Fatal(err)
+		}
+		cond := meta.FindStatusCondition(ts.Status.Conditions, hybridscalingv2.ConditionServiceFound)
+		if cond == nil || cond.Status != tc.wantStatus || cond.Reason != tc.wantReason {
+			t.Errorf("%s: ServiceFound = %+v, want %s with reason %s", tc.name, cond, tc.wantStatus, tc.wantReason)
+		}
+		if tc.wantReason == "NamespaceNotWatched" && result != (ctrl.Result{}) {
+			t.Errorf("%s: Reconcile() = %+v, want no requeue", tc.name, result)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"knative.dev/serving/pkg/apis/autoscaling"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+	autoscalerconfig "knative.dev/serving/pkg/autoscaler/config"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+const (
+	// DefaultKnativeServingNamespace is where Knative Serving keeps its config-autoscaler ConfigMap.
+	DefaultKnativeServingNamespace = "knative-serving"
+	// defaultTargetUtilization is Knative's default container-concurrency-target-percentage.
+	defaultTargetUtilization = 0.7
+)
+
+// targetUtilization resolves the fraction of the optimal concurrency KPA loads each pod of the service with,
+// and where it was taken from: the service's target-utilization-percentage annotation, the profile,
+// Knative's config-autoscaler ConfigMap, or the Knative default.
+// The annotation is skipped when the controller manages it, as it then only holds the last decision's value.
+func (r *TrafficStatReconciler) targetUtilization(ctx context.Context, svc *servingv1.Service, profile *hybridscalingv2.HybridScalingProfileSpec, unit hybridscalingv2.TrafficUnit, managed bool) (float64, string, error) {
+	if key, value, ok := autoscaling.TargetUtilizationPercentageAnnotation.Get(svc.Spec.Template.Annotations); ok && !managed {
+		percentage, err := strconv.ParseFloat(value, 64)
+		if err == nil && percentage >= 1 && percentage <= 100 {
+			return percentage / 100, "annotation " + key, nil
+		}
+		loggerSD.Info("Ignoring invalid target utilization annotation", "SERVICE_NAME", svc.Name, "ANNOTATION", key, "VALUE", value)
+	}
+
+	if profile.TargetUtilizationPercentage != nil {
+		return float64(*profile.TargetUtilizationPercentage) / 100, "profile", nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	err := r.autoscalerConfigReader().Get(ctx, client.ObjectKey{Namespace: r.knativeServingNamespace(), Name: autoscalerconfig.ConfigName}, cm)
+	switch {
+	case err == nil:
+		config, err := autoscalerconfig.NewConfigFromConfigMap(cm)
+		if err != nil {
+			loggerSD.Error(err, "Ignoring invalid Knative autoscaler config", "CONFIG_MAP-NAME", cm.Name)
+			break
+		}
+		// KPA applies container-concurrency-target-percentage to the concurrency metric only
+		if unit == hybridscalingv2.TrafficUnitRPS {
+			return config.TargetUtilization, autoscalerconfig.ConfigName, nil
+		}
+		return config.ContainerConcurrencyTargetFraction, autoscalerconfig.ConfigName, nil
+	case !apierrors.IsNotFound(err):
+		return 0, "", err
+	}
+
+	return defaultTargetUtilization, "default", nil
+}
+
+// knativeServingNamespace returns the namespace of the config-autoscaler ConfigMap.
+func (r *TrafficStatReconciler) knativeServingNamespace() string {
+	if r.KnativeServingNamespace != "" {
+		return r.KnativeServingNamespace
+	}
+	return DefaultKnativeServingNamespace
+}
+
+// autoscalerConfigReader returns the reader for config-autoscaler, its own cache once the controller is set up.
+func (r *TrafficStatReconciler) autoscalerConfigReader() client.Reader {
+	if r.autoscalerConfig != nil {
+		return r.autoscalerConfig
+	}
+	return r.Client
+}
+
+// requestsForAutoscalerConfig enqueues every TrafficStat when config-autoscaler changes,
+// as each decision depends on the target utilization.
+func (r *TrafficStatReconciler) requestsForAutoscalerConfig(_ client.Object) []reconcile.Request {
+	var trafficStats hybridscalingv2.TrafficStatList
+	if err := r.List(context.Background(), &trafficStats); err != nil {
+		loggerSD.Error(err, "unable to list TrafficStats for config-autoscaler change")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(trafficStats.Items))
+	for _, ts := range trafficStats.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ts)})
+	}
+	return requests
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestTargetUtilizationPrecedence(t *testing.T) {
+	autoscalerConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "config-autoscaler", Namespace: DefaultKnativeServingNamespace},
+		Data:       map[string]string{"container-concurrency-target-percentage": "80"},
+	}
+	annotated := &servingv1.Service{}
+	annotated.Spec.Template.Annotations = map[string]string{"autoscaling.knative.dev/target-utilization-percentage": "90"}
+	profileOverride := int32(60)
+
+	for _, tc := range []struct {
+		name    string
+		objects []client.Object
+		svc     *servingv1.Service
+		profile *hybridscalingv2.HybridScalingProfileSpec
+		unit    hybridscalingv2.TrafficUnit
+		want    float64
+	}{
+		{name: "annotation", objects: []client.Object{autoscalerConfig}, svc: annotated,
+			profile: &hybridscalingv2.HybridScalingProfileSpec{TargetUtilizationPercentage: &profileOverride}, want: 0.9},
+		{name: "profile", objects: []client.Object{autoscalerConfig}, svc: &servingv1.Service{},
+			profile: &hybridscalingv2.HybridScalingProfileSpec{TargetUtilizationPercentage: &profileOverride}, want: 0.6},
+		{name: "config-autoscaler", objects: []client.Object{autoscalerConfig}, svc: &servingv1.Service{},
+			profile: &hybridscalingv2.HybridScalingProfileSpec{}, want: 0.8},
+		{name: "config-autoscaler rps", objects: []client.Object{autoscalerConfig}, svc: &servingv1.Service{},
+			profile: &hybridscalingv2.HybridScalingProfileSpec{}, unit: hybridscalingv2.TrafficUnitRPS, want: 0.7},
+		{name: "default", svc: &servingv1.Service{}, profile: &hybridscalingv2.HybridScalingProfileSpec{}, want: 0.7},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &TrafficStatReconciler{Client: newTestClient(t, tc.objects...)}
+			got, source, err := r.targetUtilization(context.Background(), tc.svc, tc.profile, tc.unit, false)
+			if err != nil {
+				t.Fatalf("targetUtilization() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("targetUtilization() = %v from %s, want %v", got, source, tc.want)
+			}
+		})
+	}
+}
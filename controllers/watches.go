@@ -0,0 +1,131 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+const (
+	// targetServiceIndex indexes TrafficStats by the namespace/name of their Knative Service.
+	targetServiceIndex = ".spec.targetRef"
+	// profileIndex indexes TrafficStats by the namespace/name of their HybridScalingProfile.
+	profileIndex = ".spec.profileRef"
+)
+
+// indexKey is the value a TrafficStat is indexed with for an object of the given namespace and name.
+func indexKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// indexTargetService returns the targetServiceIndex value of a TrafficStat.
+func indexTargetService(obj client.Object) []string {
+	ts := obj.(*hybridscalingv2.TrafficStat)
+	return []string{indexKey(targetNamespace(ts), targetServiceName(ts))}
+}
+
+// indexProfile returns the profileIndex values of a TrafficStat: its profile, and the namespace default profile
+// of the NamespaceDefault fallback.
+func indexProfile(obj client.Object) []string {
+	ts := obj.(*hybridscalingv2.TrafficStat)
+	keys := []string{indexKey(targetNamespace(ts), profileName(ts))}
+	if fallback := fallbackPolicy(ts); fallback.Mode == hybridscalingv2.FallbackNamespaceDefault && fallback.ProfileName != profileName(ts) {
+		keys = append(keys, indexKey(targetNamespace(ts), fallback.ProfileName))
+	}
+	return keys
+}
+
+// requestsFor enqueues the TrafficStats indexed with the key.
+func (r *TrafficStatReconciler) requestsFor(index, key string) []reconcile.Request {
+	var trafficStats hybridscalingv2.TrafficStatList
+	if err := r.List(context.Background(), &trafficStats, client.MatchingFields{index: key}); err != nil {
+		loggerSD.Error(err, "unable to list TrafficStats", "INDEX", index, "KEY", key)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(trafficStats.Items))
+	for i := range trafficStats.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&trafficStats.Items[i])})
+	}
+	return requests
+}
+
+// requestsForService enqueues the TrafficStats that target the Knative Service.
+func (r *TrafficStatReconciler) requestsForService(obj client.Object) []reconcile.Request {
+	return r.requestsFor(targetServiceIndex, indexKey(obj.GetNamespace(), obj.GetName()))
+}
+
+// requestsForRevision enqueues the TrafficStats that target the Knative Service of the Revision.
+func (r *TrafficStatReconciler) requestsForRevision(obj client.Object) []reconcile.Request {
+	service := obj.GetLabels()[serving.ServiceLabelKey]
+	if service == "" {
+		return nil
+	}
+	return r.requestsFor(targetServiceIndex, indexKey(obj.GetNamespace(), service))
+}
+
+// requestsForProfile enqueues the TrafficStats that use the HybridScalingProfile.
+func (r *TrafficStatReconciler) requestsForProfile(obj client.Object) []reconcile.Request {
+	return r.requestsFor(profileIndex, indexKey(obj.GetNamespace(), obj.GetName()))
+}
+
+// requestsForLegacyProfile enqueues the TrafficStats whose service the legacy "hybrid-<service>" ConfigMap describes.
+func (r *TrafficStatReconciler) requestsForLegacyProfile(obj client.Object) []reconcile.Request {
+	return r.requestsFor(targetServiceIndex, indexKey(obj.GetNamespace(), strings.TrimPrefix(obj.GetName(), legacyProfilePrefix)))
+}
+
+// legacyProfilePredicate passes the ConfigMaps named like a legacy hybrid profile.
+var legacyProfilePredicate = predicate.NewPredicateFuncs(func(obj client.Object) bool {
+	return strings.HasPrefix(obj.GetName(), legacyProfilePrefix)
+})
+
+// serviceChangedPredicate passes spec changes of a Knative Service and changes of its created or ready revision.
+// Other status updates, such as the traffic observed by the route, do not change any decision.
+var serviceChangedPredicate = predicate.Or(predicate.GenerationChangedPredicate{}, predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldService, okOld := e.ObjectOld.(*servingv1.Service)
+		newService, okNew := e.ObjectNew.(*servingv1.Service)
+		return okOld && okNew && (oldService.Status.LatestCreatedRevisionName != newService.Status.LatestCreatedRevisionName ||
+			oldService.Status.LatestReadyRevisionName != newService.Status.LatestReadyRevisionName)
+	},
+})
+
+// revisionReadinessPredicate passes Revisions that are created, deleted, or become ready or failed.
+var revisionReadinessPredicate = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldRevision, okOld := e.ObjectOld.(*servingv1.Revision)
+		newRevision, okNew := e.ObjectNew.(*servingv1.Revision)
+		return okOld && okNew && (oldRevision.IsReady() != newRevision.IsReady() || oldRevision.IsFailed() != newRevision.IsFailed())
+	},
+}
+
+// deletionPredicate passes TrafficStats whose deletion started, the finalizer has to give their service back.
+var deletionPredicate = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !e.ObjectNew.GetDeletionTimestamp().IsZero()
+	},
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"knative.dev/serving/pkg/apis/serving"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+func TestRequestsForWatchedObjects(t *testing.T) {
+	trafficStats := []client.Object{
+		&hybridscalingv2.TrafficStat{ObjectMeta: metav1.ObjectMeta{Name: "service-a-traffic", Namespace: "tenant-a"},
+			Spec: hybridscalingv2.TrafficStatSpec{TargetRef: hybridscalingv2.TargetReference{Name: "service-a"}}},
+		// Targets service-a of tenant-a from another namespace, with a shared profile
+		&hybridscalingv2.TrafficStat{ObjectMeta: metav1.ObjectMeta{Name: "remote", Namespace: "ops"},
+			Spec: hybridscalingv2.TrafficStatSpec{TargetRef: hybridscalingv2.TargetReference{Name: "service-a", Namespace: "tenant-a"},
+				ProfileRef: &corev1.LocalObjectReference{Name: "shared"}}},
+		&hybridscalingv2.TrafficStat{ObjectMeta: metav1.ObjectMeta{Name: "service-b-traffic", Namespace: "tenant-a"},
+			Spec: hybridscalingv2.TrafficStatSpec{TargetRef: hybridscalingv2.TargetReference{Name: "service-b"}}},
+	}
+	r := &TrafficStatReconciler{Client: newTestClientBuilder(t).WithObjects(trafficStats...).
+		WithIndex(&hybridscalingv2.TrafficStat{}, targetServiceIndex, indexTargetService).
+		WithIndex(&hybridscalingv2.TrafficStat{}, profileIndex, indexProfile).
+		Build()}
+
+	objectMeta := metav1.ObjectMeta{Name: "service-a", Namespace: "tenant-a"}
+	revision := &servingv1.Revision{ObjectMeta: metav1.ObjectMeta{Name: "service-a-00002", Namespace: "tenant-a",
+		Labels: map[string]string{serving.ServiceLabelKey: "service-a"}}}
+	for _, tc := range []struct {
+		name string
+		got  int
+		want int
+	}{
+		{name: "service", got: len(r.requestsForService(&servingv1.Service{ObjectMeta: objectMeta})), want: 2},
+		{name: "revision", got: len(r.requestsForRevision(revision)), want: 2},
+		{name: "legacy profile", got: len(r.requestsForLegacyProfile(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "hybrid-service-b", Namespace: "tenant-a"}})), want: 1},
+		{name: "profile", got: len(r.requestsForProfile(&hybridscalingv2.HybridScalingProfile{ObjectMeta: objectMeta})), want: 1},
+		{name: "shared profile", got: len(r.requestsForProfile(&hybridscalingv2.HybridScalingProfile{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "tenant-a"}})), want: 1},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: %d TrafficStats enqueued, want %d", tc.name, tc.got, tc.want)
+		}
+	}
+}
@@ -7,14 +7,15 @@ require (
 	github.com/onsi/gomega v1.24.1
 	k8s.io/apimachinery v0.26.1
 	k8s.io/client-go v0.26.1
+	knative.dev/pkg v0.0.0-20230224205330-75da922ef055
 	sigs.k8s.io/controller-runtime v0.14.6
 )
 
 require (
 	github.com/blendle/zapdriver v1.3.1 // indirect
+	github.com/evanphx/json-patch v4.12.0+incompatible // indirect
 	github.com/google/go-containerregistry v0.8.1-0.20220414143355-892d7a808387 // indirect
 	knative.dev/networking v0.0.0-20230225001731-5e096d63b0cb // indirect
-	knative.dev/pkg v0.0.0-20230224205330-75da922ef055 // indirect
 )
 
 require (
@@ -65,7 +66,7 @@ require (
 	gopkg.in/inf.v0 v0.9.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
-	k8s.io/api v0.26.1 // indirect
+	k8s.io/api v0.26.1
 	k8s.io/apiextensions-apiserver v0.26.1 // indirect
 	k8s.io/component-base v0.26.1 // indirect
 	k8s.io/klog/v2 v2.80.2-0.20221028030830-9ae4992afb54 // indirect
@@ -19,6 +19,8 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,10 +30,15 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	autoscalingv1alpha1 "knative.dev/serving/pkg/apis/autoscaling/v1alpha1"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+
 	hybridscalingv1 "github.com/mipearlska/knative_hybrid_scaling/api/v1"
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
 	"github.com/mipearlska/knative_hybrid_scaling/controllers"
 	//+kubebuilder:scaffold:imports
 )
@@ -43,8 +50,11 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(servingv1.AddToScheme(scheme))
+	utilruntime.Must(autoscalingv1alpha1.AddToScheme(scheme))
 
 	utilruntime.Must(hybridscalingv1.AddToScheme(scheme))
+	utilruntime.Must(hybridscalingv2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -52,11 +62,24 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var watchNamespaces string
+	var maxConcurrentReconciles int
+	var revisionReadyTimeout time.Duration
+	var knativeServingNamespace string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma-separated list of namespaces the controller watches. "+
+			"Leave empty to watch TrafficStats and Knative Services in all namespaces.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 4,
+		"Maximum number of TrafficStats reconciled in parallel, i.e. of services rolled out at once.")
+	flag.DurationVar(&revisionReadyTimeout, "revision-ready-timeout", 10*time.Minute,
+		"How long a new Knative revision may take to become Ready before its rollout is marked as failed.")
+	flag.StringVar(&knativeServingNamespace, "knative-serving-namespace", controllers.DefaultKnativeServingNamespace,
+		"Namespace of Knative Serving, whose config-autoscaler ConfigMap provides the default target utilization.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,7 +88,14 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	var namespaces []string
+	for _, ns := range strings.Split(watchNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+
+	mgrOptions := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
@@ -83,19 +113,39 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
-	})
+	}
+	if len(namespaces) > 0 {
+		setupLog.Info("restricting controller to namespaces", "namespaces", namespaces)
+		mgrOptions.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), mgrOptions)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.TrafficStatReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		RevisionReadyTimeout:    revisionReadyTimeout,
+		KnativeServingNamespace: knativeServingNamespace,
+		Recorder:                mgr.GetEventRecorderFor("trafficstat-controller"),
+		WatchNamespaces:         namespaces,
+		CheckCapacity:           len(namespaces) == 0,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TrafficStat")
 		os.Exit(1)
 	}
+	// The conversion webhook serves v1 TrafficStats (e.g. from the Predictive_TrafficStatCRD producer)
+	// from the v2 storage version. Set ENABLE_WEBHOOKS=false to run the controller locally without certificates.
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&hybridscalingv2.TrafficStat{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TrafficStat")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,60 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package optimizer
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeCapacity is what a node has left for new pods: its allocatable resources minus the requests of its pods.
+type NodeCapacity struct {
+	// Name of the node.
+	Name string
+	// Free resources of the node, a resource the node does not list is not available on it.
+	Free corev1.ResourceList
+}
+
+// Fits reports whether the given number of pods with the given requests can all be placed on the nodes.
+// The pods all have the same size, so placing as many as fit on each node in turn is an exact bin packing.
+func Fits(nodes []NodeCapacity, requests corev1.ResourceList, pods int32) bool {
+	remaining := int64(pods)
+	for _, node := range nodes {
+		if remaining <= 0 {
+			break
+		}
+		remaining -= podsOnNode(node, requests, remaining)
+	}
+	return remaining <= 0
+}
+
+// podsOnNode returns how many pods with the given requests fit on the node, at most max.
+func podsOnNode(node NodeCapacity, requests corev1.ResourceList, max int64) int64 {
+	count := max
+	for name, request := range requests {
+		if request.Sign() <= 0 {
+			continue
+		}
+		free, ok := node.Free[name]
+		if !ok || free.Sign() <= 0 {
+			return 0
+		}
+		if n := free.MilliValue() / request.MilliValue(); n < count {
+			count = n
+		}
+	}
+	return count
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package optimizer chooses the resource-concurrency pair of a hybrid profile for a traffic prediction.
+// Strategies implement Optimizer and are selected by name, so new ones can be added without touching the reconciler.
+package optimizer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+// DefaultStrategy is used when neither the TrafficStat nor the profile names a strategy.
+const DefaultStrategy = MinTotalResourcesStrategy
+
+// Prediction is the traffic a decision has to serve.
+type Prediction struct {
+	// Traffic is the predicted traffic in Unit. It must not be negative.
+	Traffic float64
+	// Unit of Traffic, profile concurrencies are read in the same unit.
+	Unit hybridscalingv2.TrafficUnit
+}
+
+// Constraints limit how a profile entry may be used and how equal decisions are ordered.
+type Constraints struct {
+	// TargetUtilization is the fraction of an entry's optimal concurrency a pod is loaded with, in (0, 1].
+	TargetUtilization float64
+	// NodeShape is the allocatable resources of the largest schedulable node, used by the NodeShape objective.
+	// When it is unknown, resources are weighed by the largest amount an entry of the profile asks for.
+	NodeShape corev1.ResourceList
+	// TieBreak orders decisions a strategy ranks equal. Defaults to DefaultTieBreak.
+	TieBreak []hybridscalingv2.TieBreaker
+}
+
+// DefaultTieBreak orders equal decisions when no tie-break rule is given.
+var DefaultTieBreak = []hybridscalingv2.TieBreaker{hybridscalingv2.TieBreakFewerPods, hybridscalingv2.TieBreakSmallerLevel}
+
+// Decision is one profile entry evaluated for a prediction.
+type Decision struct {
+	// Entry is the evaluated profile entry.
+	Entry hybridscalingv2.ProfileEntry
+	// Resources are the requests and limits of one pod of Entry.
+	Resources corev1.ResourceList
+	// Pods is the number of pods needed to serve the prediction with Entry.
+	Pods int32
+	// Total is Pods times Resources.
+	Total corev1.ResourceList
+	// Cost is Total weighed with the profile's objective, strategies compare decisions on it.
+	Cost float64
+	// PodCost is Resources weighed with the profile's objective, the SmallerLevel tie-break compares it.
+	PodCost float64
+}
+
+// Optimizer ranks the entries of a profile for a prediction.
+type Optimizer interface {
+	// Rank returns a decision for every profile entry, best first.
+	Rank(profile *hybridscalingv2.HybridScalingProfileSpec, prediction Prediction, constraints Constraints) ([]Decision, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Optimizer{}
+)
+
+// Register makes an optimizer available under name. Registering a name twice panics.
+func Register(name string, o Optimizer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("optimizer: Register called twice for " + name)
+	}
+	registry[name] = o
+}
+
+// Get returns the optimizer registered under name, or the default one when name is empty.
+func Get(name string) (Optimizer, error) {
+	if name == "" {
+		name = DefaultStrategy
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	o, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown optimizer strategy %q", name)
+	}
+	return o, nil
+}
+
+// Evaluate computes the decision of every profile entry, in profile order.
+// Strategies use it and only differ in how they order the result.
+func Evaluate(profile *hybridscalingv2.HybridScalingProfileSpec, prediction Prediction, constraints Constraints) ([]Decision, error) {
+	if prediction.Traffic < 0 {
+		return nil, fmt.Errorf("traffic %v must not be negative", prediction.Traffic)
+	}
+	if constraints.TargetUtilization <= 0 || constraints.TargetUtilization > 1 {
+		return nil, fmt.Errorf("target utilization %v must be in (0, 1]", constraints.TargetUtilization)
+	}
+	weights := objectiveWeights(profile, constraints)
+	decisions := make([]Decision, 0, len(profile.Entries))
+	for i := range profile.Entries {
+		entry := &profile.Entries[i]
+		resources := profile.EntryResources(entry)
+		pods := math.Ceil(prediction.Traffic / (float64(entry.OptimalConcurrency) * constraints.TargetUtilization))
+		if pods > math.MaxInt32 {
+			return nil, fmt.Errorf("traffic %v needs more than %d pods with %s", prediction.Traffic, int32(math.MaxInt32), FormatResources(resources))
+		}
+		total := corev1.ResourceList{}
+		podCost := 0.
+		for name, quantity := range resources {
+			total[name] = *resource.NewMilliQuantity(quantity.MilliValue()*int64(pods), quantity.Format)
+			podCost += weights[name] * float64(quantity.MilliValue())
+		}
+		decisions = append(decisions, Decision{
+			Entry:     *entry.DeepCopy(),
+			Resources: resources,
+			Pods:      int32(pods),
+			Total:     total,
+			Cost:      podCost * pods,
+			PodCost:   podCost,
+		})
+	}
+	return decisions, nil
+}
+
+// objectiveWeights returns the weight of a milli unit of each resource in the cost of a decision.
+func objectiveWeights(profile *hybridscalingv2.HybridScalingProfileSpec, constraints Constraints) map[corev1.ResourceName]float64 {
+	weighting := hybridscalingv2.ObjectiveNodeShape
+	if profile.Objective != nil && profile.Objective.Weighting != "" {
+		weighting = profile.Objective.Weighting
+	} else if levelsOnly(profile) {
+		weighting = hybridscalingv2.ObjectiveIntensiveResource
+	}
+
+	weights := map[corev1.ResourceName]float64{}
+	switch weighting {
+	case hybridscalingv2.ObjectiveIntensiveResource:
+		weights[profile.IntensiveResourceType] = 1
+	case hybridscalingv2.ObjectivePrice:
+		for _, price := range profile.Objective.Prices {
+			unit := int64(1000)
+			if price.Unit != nil && price.Unit.Sign() > 0 {
+				unit = price.Unit.MilliValue()
+			}
+			weights[price.Resource] = price.Price.AsApproximateFloat64() / float64(unit)
+		}
+	case hybridscalingv2.ObjectiveNodeShape:
+		// A resource weighs the share of the node it takes, or of the largest entry when the node shape is unknown
+		largest := map[corev1.ResourceName]int64{}
+		for i := range profile.Entries {
+			for name, quantity := range profile.EntryResources(&profile.Entries[i]) {
+				if quantity.MilliValue() > largest[name] {
+					largest[name] = quantity.MilliValue()
+				}
+			}
+		}
+		for name, amount := range largest {
+			if allocatable, ok := constraints.NodeShape[name]; ok && allocatable.Sign() > 0 {
+				amount = allocatable.MilliValue()
+			}
+			if amount > 0 {
+				weights[name] = 1 / float64(amount)
+			}
+		}
+	}
+	return weights
+}
+
+// levelsOnly reports whether every entry of the profile gives a level of the intensive resource.
+func levelsOnly(profile *hybridscalingv2.HybridScalingProfileSpec) bool {
+	for i := range profile.Entries {
+		if len(profile.Entries[i].ResourceList) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatResources formats a resource list as name=quantity pairs in name order, e.g. cpu=1500m,memory=1Gi.
+func FormatResources(resources corev1.ResourceList) string {
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		quantity := resources[corev1.ResourceName(name)]
+		pairs = append(pairs, name+"="+quantity.String())
+	}
+	return strings.Join(pairs, ",")
+}
+
+// SameResources reports whether two resource lists hold the same quantities of the same resources.
+func SameResources(a, b corev1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, quantity := range a {
+		other, ok := b[name]
+		if !ok || quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rankBy evaluates the profile and sorts the decisions with less. Decisions less does not tell apart are
+// ordered by the tie-break rules, then by higher concurrency, and keep profile order when still equal,
+// so the same prediction always ranks the same way.
+func rankBy(profile *hybridscalingv2.HybridScalingProfileSpec, prediction Prediction, constraints Constraints, less func(a, b *Decision) bool) ([]Decision, error) {
+	decisions, err := Evaluate(profile, prediction, constraints)
+	if err != nil {
+		return nil, err
+	}
+	tieBreak := constraints.TieBreak
+	if len(tieBreak) == 0 {
+		tieBreak = DefaultTieBreak
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		a, b := &decisions[i], &decisions[j]
+		if less(a, b) || less(b, a) {
+			return less(a, b)
+		}
+		return breakTie(tieBreak, a, b)
+	})
+	return decisions, nil
+}
+
+// breakTie reports whether a goes before b under the first tie-break rule that tells them apart,
+// else whether it has the higher concurrency.
+func breakTie(rules []hybridscalingv2.TieBreaker, a, b *Decision) bool {
+	for _, rule := range rules {
+		switch rule {
+		case hybridscalingv2.TieBreakFewerPods, hybridscalingv2.TieBreakMorePods:
+			if a.Pods != b.Pods {
+				return (a.Pods < b.Pods) == (rule == hybridscalingv2.TieBreakFewerPods)
+			}
+		case hybridscalingv2.TieBreakSmallerLevel, hybridscalingv2.TieBreakLargerLevel:
+			if !sameCost(a.PodCost, b.PodCost) {
+				return (a.PodCost < b.PodCost) == (rule == hybridscalingv2.TieBreakSmallerLevel)
+			}
+		}
+	}
+	return a.Entry.OptimalConcurrency > b.Entry.OptimalConcurrency
+}
+
+// cheaper reports whether cost a is below cost b. Costs within floating point rounding of each other are equal.
+func cheaper(a, b float64) bool {
+	return a < b && !sameCost(a, b)
+}
+
+// sameCost reports whether two costs are equal up to floating point rounding.
+func sameCost(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
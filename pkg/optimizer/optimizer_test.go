@@ -0,0 +1,210 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package optimizer
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+// testProfile is the running_prequisites/testprofile.yaml profile.
+func testProfile() *hybridscalingv2.HybridScalingProfileSpec {
+	profile := &hybridscalingv2.HybridScalingProfileSpec{IntensiveResourceType: corev1.ResourceCPU}
+	for _, e := range []struct {
+		level       string
+		concurrency int32
+	}{{"1000m", 6}, {"1500m", 10}, {"2000m", 15}, {"2500m", 20}, {"3000m", 30}, {"3500m", 40}, {"4000m", 50}} {
+		profile.Entries = append(profile.Entries, hybridscalingv2.ProfileEntry{
+			Resources:          resource.MustParse(e.level),
+			OptimalConcurrency: e.concurrency,
+		})
+	}
+	return profile
+}
+
+var defaultConstraints = Constraints{TargetUtilization: 0.7}
+
+func TestEvaluate(t *testing.T) {
+	decisions, err := Evaluate(testProfile(), Prediction{Traffic: 100}, defaultConstraints)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	// ceil(100 / (6 * 0.7)) = 24 pods of 1000m
+	if got, total := decisions[0], decisions[0].Total[corev1.ResourceCPU]; got.Pods != 24 || total.Cmp(resource.MustParse("24")) != 0 {
+		t.Errorf("first decision = %d pods, %s total, want 24 pods, 24 total", got.Pods, total.String())
+	}
+	zero, err := Evaluate(testProfile(), Prediction{Traffic: 0}, defaultConstraints)
+	if err != nil || zero[0].Pods != 0 {
+		t.Errorf("Evaluate() with no traffic = %v, %v, want 0 pods", zero, err)
+	}
+}
+
+func TestEvaluateRejectsInvalidInput(t *testing.T) {
+	if _, err := Evaluate(testProfile(), Prediction{Traffic: -1}, defaultConstraints); err == nil {
+		t.Error("Evaluate() accepted negative traffic")
+	}
+	if _, err := Evaluate(testProfile(), Prediction{Traffic: 1}, Constraints{}); err == nil {
+		t.Error("Evaluate() accepted a zero target utilization")
+	}
+}
+
+func TestMinTotalResources(t *testing.T) {
+	for _, tc := range []struct {
+		traffic   float64
+		wantLevel string
+		wantPods  int32
+	}{
+		// 4000m x 3 pods = 12 cores is the smallest total for 100 concurrent requests
+		{traffic: 100, wantLevel: "4000m", wantPods: 3},
+		// A single pod serves 4 concurrent requests at every level, the smallest level wins
+		{traffic: 4, wantLevel: "1000m", wantPods: 1},
+	} {
+		decisions, err := MinTotalResources{}.Rank(testProfile(), Prediction{Traffic: tc.traffic}, defaultConstraints)
+		if err != nil {
+			t.Fatalf("Rank() error = %v", err)
+		}
+		if got := decisions[0]; got.Entry.Resources.Cmp(resource.MustParse(tc.wantLevel)) != 0 || got.Pods != tc.wantPods {
+			t.Errorf("traffic %v: best = %s x %d, want %s x %d", tc.traffic, got.Entry.Resources.String(), got.Pods, tc.wantLevel, tc.wantPods)
+		}
+		for i := 1; i < len(decisions); i++ {
+			if decisions[i-1].Cost > decisions[i].Cost {
+				t.Errorf("traffic %v: decision %d is ranked before a smaller total", tc.traffic, i-1)
+			}
+		}
+	}
+}
+
+func TestMinPods(t *testing.T) {
+	decisions, err := MinPods{}.Rank(testProfile(), Prediction{Traffic: 100}, defaultConstraints)
+	if err != nil {
+		t.Fatalf("Rank() error = %v", err)
+	}
+	// ceil(100 / (50 * 0.7)) = 3 pods of 4000m
+	if got := decisions[0]; got.Entry.Resources.Cmp(resource.MustParse("4000m")) != 0 || got.Pods != 3 {
+		t.Errorf("best = %s x %d, want 4000m x 3", got.Entry.Resources.String(), got.Pods)
+	}
+}
+
+func TestTieBreak(t *testing.T) {
+	// 2 pods of 1000m and 1 pod of 2000m both total 2 cores for 14 concurrent requests
+	profile := &hybridscalingv2.HybridScalingProfileSpec{
+		IntensiveResourceType: corev1.ResourceCPU,
+		Entries: []hybridscalingv2.ProfileEntry{
+			{Resources: resource.MustParse("1000m"), OptimalConcurrency: 10},
+			{Resources: resource.MustParse("2000m"), OptimalConcurrency: 20},
+		},
+	}
+	for _, tc := range []struct {
+		tieBreak  []hybridscalingv2.TieBreaker
+		wantLevel string
+	}{
+		{tieBreak: nil, wantLevel: "2000m"},
+		{tieBreak: []hybridscalingv2.TieBreaker{hybridscalingv2.TieBreakMorePods}, wantLevel: "1000m"},
+		{tieBreak: []hybridscalingv2.TieBreaker{hybridscalingv2.TieBreakSmallerLevel}, wantLevel: "1000m"},
+		{tieBreak: []hybridscalingv2.TieBreaker{hybridscalingv2.TieBreakLargerLevel}, wantLevel: "2000m"},
+	} {
+		constraints := Constraints{TargetUtilization: 1, TieBreak: tc.tieBreak}
+		decisions, err := MinTotalResources{}.Rank(profile, Prediction{Traffic: 14}, constraints)
+		if err != nil {
+			t.Fatalf("Rank() error = %v", err)
+		}
+		if got := decisions[0].Entry.Resources; got.Cmp(resource.MustParse(tc.wantLevel)) != 0 {
+			t.Errorf("tie-break %v: best = %s, want %s", tc.tieBreak, got.String(), tc.wantLevel)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	if o, err := Get(""); err != nil || o != (MinTotalResources{}) {
+		t.Errorf("Get(\"\") = %v, %v, want the MinTotalResources default", o, err)
+	}
+	if _, err := Get(MinPodsStrategy); err != nil {
+		t.Errorf("Get(%q) error = %v", MinPodsStrategy, err)
+	}
+	if _, err := Get("Cheapest"); err == nil {
+		t.Error("Get() returned an optimizer for an unknown strategy")
+	}
+}
+
+func TestFits(t *testing.T) {
+	nodes := []NodeCapacity{
+		{Name: "edge-1", Free: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3500m"), corev1.ResourceMemory: resource.MustParse("4Gi")}},
+		{Name: "edge-2", Free: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("4Gi")}},
+	}
+	small := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1000m"), corev1.ResourceMemory: resource.MustParse("200Mi")}
+	large := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4000m"), corev1.ResourceMemory: resource.MustParse("200Mi")}
+	// 3 pods of 1000m on edge-1 and 2 on edge-2
+	if !Fits(nodes, small, 5) {
+		t.Errorf("Fits(5 x 1000m) = false, want true")
+	}
+	if Fits(nodes, small, 6) {
+		t.Errorf("Fits(6 x 1000m) = true, want false")
+	}
+	// 5.5 cores are free in total, but no node has 4
+	if Fits(nodes, large, 1) {
+		t.Errorf("Fits(1 x 4000m) = true, want false")
+	}
+	if !Fits(nodes, large, 0) {
+		t.Errorf("Fits(0 pods) = false, want true")
+	}
+}
+
+func TestObjective(t *testing.T) {
+	entry := func(cpu, memory string, concurrency int32) hybridscalingv2.ProfileEntry {
+		return hybridscalingv2.ProfileEntry{OptimalConcurrency: concurrency, ResourceList: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory),
+		}}
+	}
+	// Both entries serve 14 concurrent requests with 2 pods
+	profile := &hybridscalingv2.HybridScalingProfileSpec{Entries: []hybridscalingv2.ProfileEntry{
+		entry("2", "1Gi", 10),
+		entry("1", "4Gi", 10),
+	}}
+	nodeShape := Constraints{TargetUtilization: 0.7, NodeShape: corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("4"), corev1.ResourceMemory: resource.MustParse("16Gi"),
+	}}
+
+	// On a 4 cpu 16Gi node the first entry takes 50% + 6.25%, the second 25% + 25%
+	decisions, err := MinTotalResources{}.Rank(profile, Prediction{Traffic: 14}, nodeShape)
+	if err != nil {
+		t.Fatalf("Rank() error = %v", err)
+	}
+	if got := FormatResources(decisions[0].Resources); got != "cpu=1,memory=4Gi" {
+		t.Errorf("NodeShape best = %s, want cpu=1,memory=4Gi", got)
+	}
+	if got := FormatResources(decisions[0].Total); got != "cpu=2,memory=8Gi" {
+		t.Errorf("NodeShape total = %s, want cpu=2,memory=8Gi", got)
+	}
+
+	// Memory priced far above cpu favours the first entry
+	gi := resource.MustParse("1Gi")
+	profile.Objective = &hybridscalingv2.Objective{Weighting: hybridscalingv2.ObjectivePrice, Prices: []hybridscalingv2.ResourcePrice{
+		{Resource: corev1.ResourceCPU, Price: resource.MustParse("1")},
+		{Resource: corev1.ResourceMemory, Unit: &gi, Price: resource.MustParse("2")},
+	}}
+	decisions, err = MinTotalResources{}.Rank(profile, Prediction{Traffic: 14}, nodeShape)
+	if err != nil {
+		t.Fatalf("Rank() error = %v", err)
+	}
+	if got := FormatResources(decisions[0].Resources); got != "cpu=2,memory=1Gi" {
+		t.Errorf("Price best = %s, want cpu=2,memory=1Gi", got)
+	}
+}
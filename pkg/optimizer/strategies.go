@@ -0,0 +1,56 @@
+/*
+Copyright 2023 mipearlska.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package optimizer
+
+import (
+	hybridscalingv2 "github.com/mipearlska/knative_hybrid_scaling/api/v2"
+)
+
+const (
+	// MinTotalResourcesStrategy prefers the entry with the smallest total resources, weighed with the profile's objective.
+	MinTotalResourcesStrategy = "MinTotalResources"
+	// MinPodsStrategy prefers the entry that needs the fewest pods.
+	MinPodsStrategy = "MinPods"
+)
+
+func init() {
+	Register(MinTotalResourcesStrategy, MinTotalResources{})
+	Register(MinPodsStrategy, MinPods{})
+}
+
+// MinTotalResources ranks entries by the cost of pods times resources, smallest first.
+type MinTotalResources struct{}
+
+// Rank implements Optimizer.
+func (MinTotalResources) Rank(profile *hybridscalingv2.HybridScalingProfileSpec, prediction Prediction, constraints Constraints) ([]Decision, error) {
+	return rankBy(profile, prediction, constraints, func(a, b *Decision) bool {
+		return cheaper(a.Cost, b.Cost)
+	})
+}
+
+// MinPods ranks entries by the number of pods, fewest first, then by the cost of their total resources.
+type MinPods struct{}
+
+// Rank implements Optimizer.
+func (MinPods) Rank(profile *hybridscalingv2.HybridScalingProfileSpec, prediction Prediction, constraints Constraints) ([]Decision, error) {
+	return rankBy(profile, prediction, constraints, func(a, b *Decision) bool {
+		if a.Pods != b.Pods {
+			return a.Pods < b.Pods
+		}
+		return cheaper(a.Cost, b.Cost)
+	})
+}